@@ -0,0 +1,138 @@
+package b2
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HeaderOverrides are response headers that a download can override, through
+// the b2ContentDisposition family of query parameters. Empty fields are not
+// overridden.
+type HeaderOverrides struct {
+	ContentDisposition string
+	ContentLanguage    string
+	Expires            string // in RFC 1123 format, see http.TimeFormat
+	CacheControl       string
+	ContentEncoding    string
+	ContentType        string
+}
+
+// values returns the non-empty overrides keyed by their parameter names.
+func (h *HeaderOverrides) values() map[string]string {
+	v := make(map[string]string)
+	if h == nil {
+		return v
+	}
+	for name, value := range map[string]string{
+		"b2ContentDisposition": h.ContentDisposition,
+		"b2ContentLanguage":    h.ContentLanguage,
+		"b2Expires":            h.Expires,
+		"b2CacheControl":       h.CacheControl,
+		"b2ContentEncoding":    h.ContentEncoding,
+		"b2ContentType":        h.ContentType,
+	} {
+		if value != "" {
+			v[name] = value
+		}
+	}
+	return v
+}
+
+// query returns the overrides as URL query parameters.
+func (h *HeaderOverrides) query() url.Values {
+	q := url.Values{}
+	for name, value := range h.values() {
+		q.Set(name, value)
+	}
+	return q
+}
+
+// A DownloadAuthorization is the result of (*Bucket).GetDownloadAuthorization.
+type DownloadAuthorization struct {
+	BucketID           string
+	FileNamePrefix     string
+	AuthorizationToken string
+}
+
+// maxDownloadAuthorizationDuration is the longest validity B2 allows.
+const maxDownloadAuthorizationDuration = 7 * 24 * time.Hour
+
+// GetDownloadAuthorization calls b2_get_download_authorization to obtain a
+// token that allows downloading by name, without any other credential, the
+// files of the bucket with names starting with fileNamePrefix, for valid
+// (between one second and one week).
+//
+// If overrides is not nil, downloads made with the token must request the
+// same overrides, as DownloadURLByName does when passed them.
+func (b *Bucket) GetDownloadAuthorization(fileNamePrefix string, valid time.Duration, overrides *HeaderOverrides) (*DownloadAuthorization, error) {
+	if valid < time.Second || valid > maxDownloadAuthorizationDuration {
+		return nil, errors.New("b2: download authorization validity must be between one second and one week")
+	}
+	params := map[string]interface{}{
+		"bucketId":               b.ID,
+		"fileNamePrefix":         fileNamePrefix,
+		"validDurationInSeconds": int64(valid / time.Second),
+	}
+	for name, value := range overrides.values() {
+		params[name] = value
+	}
+	res, err := b.c.doRequest("b2_get_download_authorization", params)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var da *DownloadAuthorization
+	if err := json.NewDecoder(res.Body).Decode(&da); err != nil {
+		return nil, err
+	}
+	return da, nil
+}
+
+// DownloadURLByName returns the URL to download the latest version of the
+// named file in the named bucket. If authToken is not "", it's added as the
+// Authorization query parameter, making the URL usable by clients without
+// credentials, like browsers, for private buckets. The token can be one
+// returned by GetDownloadAuthorization, in which case overrides must match
+// the ones it was obtained with.
+func (c *Client) DownloadURLByName(bucket, file, authToken string, overrides *HeaderOverrides) string {
+	return c.downloadURL("/file/"+bucket+"/"+escapePath(file), authToken, overrides)
+}
+
+// DownloadURLByID is like DownloadURLByName, but for the file version with
+// the given ID. Note that B2 only accepts tokens from
+// GetDownloadAuthorization for downloads by name, so authToken must be an
+// account authorization token, like LoginInfo.AuthorizationToken.
+func (c *Client) DownloadURLByID(id, authToken string, overrides *HeaderOverrides) string {
+	q := overrides.query()
+	q.Set("fileId", id)
+	if authToken != "" {
+		q.Set("Authorization", authToken)
+	}
+	downloadURL := c.loginInfo.Load().(*LoginInfo).DownloadURL
+	return downloadURL + apiPath + "b2_download_file_by_id?" + q.Encode()
+}
+
+func (c *Client) downloadURL(path, authToken string, overrides *HeaderOverrides) string {
+	q := overrides.query()
+	if authToken != "" {
+		q.Set("Authorization", authToken)
+	}
+	downloadURL := c.loginInfo.Load().(*LoginInfo).DownloadURL
+	if len(q) == 0 {
+		return downloadURL + path
+	}
+	return downloadURL + path + "?" + q.Encode()
+}
+
+// escapePath percent-encodes each segment of a file name, leaving the
+// slashes in place.
+func escapePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+	}
+	return strings.Join(segments, "/")
+}
@@ -5,7 +5,12 @@
 // Uploads to B2 require a SHA1 header, so the hash of the file must be known
 // before the upload starts. The (*Bucket).Upload API tries its best not to
 // buffer the entire file in memory, and it will avoid it if passed either a
-// bytes.Buffer or a io.ReadSeeker.
+// bytes.Buffer or a io.ReadSeeker. With UploadOptions.TrailingSHA1 the hash
+// is instead computed while uploading and sent after the body, so that an
+// io.ReadSeeker is read only once.
+//
+// To upload a stream of unknown length without buffering all of it, use
+// (*Bucket).NewWriter, which holds in memory only a few parts at a time.
 //
 // If you know the SHA1 and the length of the file in advance, you can use
 // (*Bucket).UploadWithSHA1 but you are responsible for retrying on
@@ -17,6 +22,10 @@
 // standard functions you can build your own URL according to the API docs.
 // All the information you need is returned by Client.LoginInfo().
 //
+// To let clients without credentials download files from a private bucket,
+// obtain a token with (*Bucket).GetDownloadAuthorization and build the URLs
+// with (*Client).DownloadURLByName.
+//
 // Hidden files and versions
 //
 // There is no first-class support for versions in this library, but most
@@ -24,9 +33,20 @@
 // with the same name, ListFiles will only return the latest version of
 // non-hidden files, and ListFilesVersions will return all files and versions.
 //
+// Large files
+//
+// Files larger than 5GB must be uploaded as large files, in parts. Upload
+// does that automatically for large io.ReadSeeker and io.ReaderAt inputs,
+// uploading parts in parallel. See UploadOptions for the tunables.
+//
+// The low-level API is exposed by (*Bucket).StartLargeFile and the LargeFile
+// methods, and leaves part scheduling and retries to the caller.
+//
+// Files and ranges of them can be copied server-side, including across
+// encryption settings, with (*Bucket).CopyFile and (*LargeFile).CopyPart.
+//
 // Unsupported APIs
 //
-// Large files (b2_*_large_file, b2_*_part), b2_get_download_authorization,
 // b2_hide_file, b2_update_bucket.
 //
 // Debug mode
@@ -72,8 +92,11 @@ func UnwrapError(err error) (b2Err *Error, ok bool) {
 	if e, ok := err.(*url.Error); ok {
 		err = e.Err
 	}
-	if e, ok := err.(*Error); ok {
+	switch e := err.(type) {
+	case *Error:
 		return e, true
+	case *EncryptionKeyError:
+		return e.Err, true
 	}
 	return nil, false
 }
@@ -95,6 +118,12 @@ type LoginInfo struct {
 	// AuthorizationToken is the value to pass in the Authorization
 	// header of all private calls. This is valid for at most 24 hours.
 	AuthorizationToken string
+
+	// RecommendedPartSize is the part size in bytes that B2 suggests for
+	// large files, and AbsoluteMinimumPartSize is the smallest size allowed
+	// for all parts except the last.
+	RecommendedPartSize     int64
+	AbsoluteMinimumPartSize int64
 }
 
 // LoginInfo returns the LoginInfo object currently in use. If refresh is
@@ -123,6 +152,8 @@ type Client struct {
 	loginMu sync.Mutex
 
 	hc *http.Client
+
+	uploadLimiter, downloadLimiter atomic.Value // *RateLimiter
 }
 
 // NewClient calls b2_authorize_account and returns an authenticated Client.
@@ -215,7 +246,7 @@ func (t *transport) RoundTrip(req *http.Request) (res *http.Response, err error)
 		res, err = t.t.RoundTrip(req)
 	}
 
-	if err == nil && res.StatusCode != 200 {
+	if err == nil && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
 		return nil, parseB2Error(res)
 	}
 
@@ -254,12 +285,16 @@ func (c *Client) doRequest(endpoint string, params map[string]interface{}) (*htt
 	return res, err
 }
 
+// parseB2Error decodes the JSON error in the body of res. Responses without
+// one, like those to HEAD requests, produce an Error with Code "unknown".
+// The Status is always set from the response.
 func parseB2Error(res *http.Response) error {
 	defer drainAndClose(res.Body)
 	b2Err := &Error{}
 	if err := json.NewDecoder(res.Body).Decode(b2Err); err != nil {
-		return fmt.Errorf("unknown error during b2_authorize_account: %d", res.StatusCode)
+		b2Err = &Error{Code: "unknown", Message: res.Status}
 	}
+	b2Err.Status = res.StatusCode
 	return b2Err
 }
 
@@ -276,8 +311,9 @@ type Bucket struct {
 	ID string
 	c  *Client
 
-	uploadURLs   []*uploadURL
-	uploadURLsMu sync.Mutex
+	name       string // if known, for LookupByHead
+	lookup     LookupStrategy
+	uploadURLs uploadURLPool
 }
 
 // BucketInfo is an extended Bucket object with metadata.
@@ -294,6 +330,30 @@ func (c *Client) BucketByID(id string) *Bucket {
 	return &Bucket{ID: id, c: c}
 }
 
+// BucketOptions are the optional parameters of (*Client).BucketByIDWithOptions.
+// The zero value and nil are valid and select the defaults.
+type BucketOptions struct {
+	// Name is the name of the bucket. It's required by LookupByHead.
+	Name string
+
+	// LookupStrategy selects how GetFileInfoByName finds files.
+	// The zero value is LookupByListing.
+	LookupStrategy LookupStrategy
+}
+
+// BucketByIDWithOptions is like BucketByID, but the returned Bucket uses opts
+// for its whole lifetime. It returns an error if opts.LookupStrategy is
+// LookupByHead and opts.Name is not set.
+func (c *Client) BucketByIDWithOptions(id string, opts *BucketOptions) (*Bucket, error) {
+	if opts == nil {
+		opts = &BucketOptions{}
+	}
+	if opts.LookupStrategy == LookupByHead && opts.Name == "" {
+		return nil, errors.New("b2: LookupByHead requires the bucket Name")
+	}
+	return &Bucket{ID: id, c: c, name: opts.Name, lookup: opts.LookupStrategy}, nil
+}
+
 // BucketByName returns the Bucket with the given name. If such a bucket is not
 // found and createIfNotExists is true, CreateBucket is called with allPublic set
 // to false. Otherwise, an error is returned.
@@ -334,8 +394,9 @@ func (c *Client) Buckets() ([]*BucketInfo, error) {
 	for _, b := range buckets.Buckets {
 		r = append(r, &BucketInfo{
 			Bucket: Bucket{
-				ID: b.BucketID,
-				c:  c,
+				ID:   b.BucketID,
+				c:    c,
+				name: b.BucketName,
 			},
 			Name: b.BucketName,
 			Type: b.BucketType,
@@ -368,7 +429,7 @@ func (c *Client) CreateBucket(name string, allPublic bool) (*BucketInfo, error)
 	}
 	return &BucketInfo{
 		Bucket: Bucket{
-			c: c, ID: bucket.BucketID,
+			c: c, ID: bucket.BucketID, name: name,
 		},
 		Name: name,
 		Type: bucketType,
@@ -388,3 +449,95 @@ func (b *Bucket) Delete() error {
 	drainAndClose(res.Body)
 	return nil
 }
+
+// DeleteAllOptions are the options for (*Bucket).DeleteAll. The zero value
+// and nil are valid and select the defaults.
+type DeleteAllOptions struct {
+	// Concurrency is the number of deletions performed in parallel.
+	// If zero, 10 is used.
+	Concurrency int
+
+	// DryRun, if true, makes DeleteAll only list and report what would be
+	// deleted, without deleting any file or the bucket itself.
+	DryRun bool
+
+	// Progress, if not nil, is called with each file version, hide marker
+	// and unfinished large file after it is deleted (or would have been,
+	// if DryRun is set). Calls are serialized.
+	Progress func(fi *FileInfo)
+}
+
+// DeleteAll deletes every file version, hide marker and unfinished large
+// file in the bucket, and then deletes the bucket with b2_delete_bucket.
+//
+// Unfinished large files are canceled with b2_cancel_large_file, everything
+// else is removed with b2_delete_file_version. If a deletion fails, DeleteAll
+// stops listing, waits for the deletions in flight and returns the first error.
+func (b *Bucket) DeleteAll(opts *DeleteAllOptions) error {
+	if opts == nil {
+		opts = &DeleteAllOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+
+	var (
+		wg sync.WaitGroup
+		// mu protects firstErr and serializes calls to opts.Progress
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	sem := make(chan struct{}, concurrency)
+
+	l := b.ListFilesVersions("", "")
+	l.SetPageCount(1000)
+	for !failed() && l.Next() {
+		fi := l.FileInfo()
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var err error
+			if !opts.DryRun {
+				if fi.Action == "start" {
+					err = b.c.CancelLargeFile(fi.ID)
+				} else {
+					err = b.c.DeleteFile(fi.ID, fi.Name)
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				debugf("delete all %s: %s", fi.Name, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			if opts.Progress != nil {
+				opts.Progress(fi)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := l.Err(); err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return b.Delete()
+}
@@ -1,11 +1,13 @@
 package b2_test
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/hex"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -19,12 +21,17 @@ import (
 var client *b2.Client
 var clientMu sync.Mutex
 
-func getClient(t *testing.T) *b2.Client {
-	accountID := os.Getenv("ACCOUNT_ID")
-	applicationKey := os.Getenv("APPLICATION_KEY")
+func getCredentials(t *testing.T) (accountID, applicationKey string) {
+	accountID = os.Getenv("ACCOUNT_ID")
+	applicationKey = os.Getenv("APPLICATION_KEY")
 	if accountID == "" || applicationKey == "" {
 		t.Fatal("Missing ACCOUNT_ID or APPLICATION_KEY")
 	}
+	return accountID, applicationKey
+}
+
+func getClient(t *testing.T) *b2.Client {
+	accountID, applicationKey := getCredentials(t)
 	clientMu.Lock()
 	defer clientMu.Unlock()
 	if client != nil {
@@ -61,17 +68,7 @@ func TestMain(m *testing.M) {
 				continue
 			}
 			log.Println("Deleting bucket", b.Name)
-			l := b.ListFilesVersions("", "")
-			for l.Next() {
-				fi := l.FileInfo()
-				if err := c.DeleteFile(fi.ID, fi.Name); err != nil {
-					log.Fatal(err)
-				}
-			}
-			if err := l.Err(); err != nil {
-				log.Fatal(err)
-			}
-			if err := b.Delete(); err != nil {
+			if err := b.DeleteAll(nil); err != nil {
 				log.Fatal(err)
 			}
 		}
@@ -139,3 +136,44 @@ func TestUnwrapError(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestBucketDeleteAll(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+
+	file := make([]byte, 1234)
+	rand.Read(file)
+	for i := 0; i < 5; i++ {
+		if _, err := b.Upload(bytes.NewReader(file), fmt.Sprintf("test-%d", i%3), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var n int
+	if err := b.DeleteAll(&b2.DeleteAllOptions{
+		DryRun:   true,
+		Progress: func(*b2.FileInfo) { n++ },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("dry run reported %d files, expected 5", n)
+	}
+	if _, err := c.BucketByName(b.Name, false); err != nil {
+		t.Fatal("dry run deleted the bucket:", err)
+	}
+
+	n = 0
+	if err := b.DeleteAll(&b2.DeleteAllOptions{
+		Concurrency: 2,
+		Progress:    func(*b2.FileInfo) { n++ },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("deleted %d files, expected 5", n)
+	}
+	if _, err := c.BucketByName(b.Name, false); err == nil {
+		t.Fatal("Bucket did not disappear")
+	}
+}
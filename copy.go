@@ -0,0 +1,133 @@
+package b2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// CopyOptions are the optional parameters of (*Bucket).CopyFile and
+// (*LargeFile).CopyPart. The zero value and nil are valid and select the
+// defaults.
+type CopyOptions struct {
+	// Offset and Length, if not zero, select Length bytes of the source
+	// starting at Offset. By default the whole source is copied.
+	Offset, Length int64
+
+	// ReplaceMetadata, if true, gives the new file ContentType and Info
+	// instead of the ones of the source. Otherwise they must be empty.
+	// It's ignored by CopyPart.
+	ReplaceMetadata bool
+	ContentType     string
+	Info            map[string]string
+
+	// SourceServerSideEncryption must be set, with Mode SSEC and the
+	// CustomerKey, to copy from files encrypted with SSE-C.
+	SourceServerSideEncryption *ServerSideEncryption
+
+	// ServerSideEncryption, if not nil, makes B2 encrypt the new file at
+	// rest, like UploadOptions.ServerSideEncryption. It's ignored by
+	// CopyPart, which uses the encryption of the LargeFile.
+	ServerSideEncryption *ServerSideEncryption
+}
+
+// params returns the parameters common to b2_copy_file and b2_copy_part.
+func (opts *CopyOptions) params(sourceID string) (map[string]interface{}, error) {
+	params := map[string]interface{}{
+		"sourceFileId": sourceID,
+	}
+	switch {
+	case opts.Offset < 0 || opts.Length < 0:
+		return nil, errors.New("b2: negative copy range")
+	case opts.Length > 0:
+		params["range"] = fmt.Sprintf("bytes=%d-%d", opts.Offset, opts.Offset+opts.Length-1)
+	case opts.Offset > 0:
+		return nil, errors.New("b2: copy Offset set without a Length")
+	}
+	if sse := opts.SourceServerSideEncryption; sse != nil {
+		if err := sse.check(); err != nil {
+			return nil, err
+		}
+		if sse.Mode == SSEC {
+			params["sourceServerSideEncryption"] = sse.params()
+		}
+	}
+	return params, nil
+}
+
+// CopyFile calls b2_copy_file to create a file named name in b with the
+// contents of the file version with ID sourceID, which can be in any bucket
+// of the account. The data is copied server-side, without downloading it.
+func (b *Bucket) CopyFile(sourceID, name string, opts *CopyOptions) (*FileInfo, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	params, err := opts.params(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	params["destinationBucketId"] = b.ID
+	params["fileName"] = name
+	if opts.ReplaceMetadata {
+		info, err := checkFileInfo(opts.Info)
+		if err != nil {
+			return nil, err
+		}
+		contentType := opts.ContentType
+		if contentType == "" {
+			contentType = "b2/x-auto"
+		}
+		params["metadataDirective"] = "REPLACE"
+		params["contentType"] = contentType
+		params["fileInfo"] = info
+	} else if opts.ContentType != "" || len(opts.Info) > 0 {
+		return nil, errors.New("b2: copy ContentType and Info require ReplaceMetadata")
+	}
+	if sse := opts.ServerSideEncryption; sse != nil {
+		if err := sse.check(); err != nil {
+			return nil, err
+		}
+		params["destinationServerSideEncryption"] = sse.params()
+	}
+
+	res, err := b.c.doRequest("b2_copy_file", params)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var fi fileInfoObj
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		return nil, err
+	}
+	return fi.makeFileInfo(), nil
+}
+
+// CopyPart calls b2_copy_part to create part partNumber of lf from the file
+// version with ID sourceID, or from the range of it selected by opts. Like
+// UploadPart, all parts except the last must be at least
+// LoginInfo.AbsoluteMinimumPartSize bytes long.
+func (lf *LargeFile) CopyPart(sourceID string, partNumber int, opts *CopyOptions) (*PartInfo, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	params, err := opts.params(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	params["largeFileId"] = lf.ID
+	params["partNumber"] = partNumber
+	if sse := lf.ServerSideEncryption; sse != nil && sse.Mode == SSEC {
+		params["destinationServerSideEncryption"] = sse.params()
+	}
+
+	res, err := lf.c.doRequest("b2_copy_part", params)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var pi partInfoObj
+	if err := json.NewDecoder(res.Body).Decode(&pi); err != nil {
+		return nil, err
+	}
+	return pi.makePartInfo(), nil
+}
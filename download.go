@@ -1,7 +1,11 @@
 package b2
 
 import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
@@ -9,49 +13,248 @@ import (
 )
 
 // DownloadFileByID gets file contents by file ID. The ReadCloser must be
-// closed by the caller once done reading.
+// closed by the caller once done reading. The SHA1 of the contents is
+// verified, and the final Read returns an *IntegrityError on mismatch.
+//
+// If the connection fails while reading, the download transparently resumes
+// from where it stopped, with a ranged request for the same file ID.
+//
+// To override response headers, select a range, or pass SSE-C keys or an
+// explicit authorization token, use DownloadFileByIDWithOptions.
 //
 // Note: the (*FileInfo).CustomMetadata values returned by this function are
 // all represented as strings, because they are delivered by HTTP headers.
 func (c *Client) DownloadFileByID(id string) (io.ReadCloser, *FileInfo, error) {
-	downloadURL := c.loginInfo.Load().(*LoginInfo).DownloadURL
-	res, err := c.hc.Get(downloadURL + apiPath + "b2_download_file_by_id?fileId=" + id)
-	if e, ok := UnwrapError(err); ok && e.Status == http.StatusUnauthorized {
-		if err = c.login(res); err == nil {
-			res, err = c.hc.Get(downloadURL + apiPath + "b2_download_file_by_id?fileId=" + id)
-		}
-	}
-	if err != nil {
-		debugf("download %s: %s", id, err)
-		return nil, nil, err
-	}
-	debugf("download %s (%s)", id, res.Header.Get("X-Bz-Content-Sha1"))
-
-	fi, err := parseFileInfoHeaders(res.Header)
-	return res.Body, fi, err
+	return c.DownloadFileByIDWithOptions(id, nil)
 }
 
 // DownloadFileByName gets file contents by file and bucket name.
 // The ReadCloser must be closed by the caller once done reading.
 //
+// The file name is percent-encoded in the URL, so it can contain characters
+// like spaces, '?', '#' and '%'. The same applies to all the functions that
+// download by name.
+//
 // Note: the (*FileInfo).CustomMetadata values returned by this function are
 // all represented as strings, because they are delivered by HTTP headers.
 func (c *Client) DownloadFileByName(bucket, file string) (io.ReadCloser, *FileInfo, error) {
-	downloadURL := c.loginInfo.Load().(*LoginInfo).DownloadURL
-	res, err := c.hc.Get(downloadURL + "/file/" + bucket + "/" + file)
-	if e, ok := UnwrapError(err); ok && e.Status == http.StatusUnauthorized {
-		if err = c.login(res); err == nil {
-			res, err = c.hc.Get(downloadURL + "/file/" + bucket + "/" + file)
-		}
+	return c.DownloadFileByNameWithOptions(bucket, file, nil)
+}
+
+// DownloadOptions are the optional parameters of the download functions.
+// The zero value and nil are valid and select the defaults.
+type DownloadOptions struct {
+	// ServerSideEncryption must be set, with Mode SSEC and the CustomerKey,
+	// to download files encrypted with SSE-C. Otherwise, it can be nil.
+	// A missing or wrong key causes an EncryptionKeyError.
+	ServerSideEncryption *ServerSideEncryption
+
+	// RateLimiter, if not nil, caps the bandwidth used to read the body, in
+	// addition to the Client-wide one set with SetDownloadRateLimiter.
+	RateLimiter *RateLimiter
+
+	// Progress, if not nil, is called periodically while the body is read,
+	// at most once every ProgressInterval and once when it's fully read.
+	// If ProgressInterval is zero, 500ms is used. Calls are serialized.
+	Progress         func(Progress)
+	ProgressInterval time.Duration
+
+	// Offset and Length, if not zero, select a range of the file to
+	// download: Length bytes starting at Offset, or all the bytes from
+	// Offset to the end if Length is zero. A negative Offset selects the
+	// last -Offset bytes of the file, and requires Length to be zero.
+	//
+	// The returned FileInfo describes the whole file: its ContentLength is
+	// the full size reported in the Content-Range, and its ContentSHA1 is
+	// the SHA1 of the whole file.
+	Offset, Length int64
+
+	// DisableSHA1Check, if true, disables the verification of whole file
+	// downloads. Otherwise, the SHA1 of the body is computed while it's read,
+	// and compared at the end with the one reported by B2 or, for large
+	// files, with the large_file_sha1 info if present. On mismatch, the
+	// final Read returns an *IntegrityError instead of io.EOF.
+	// Ranged downloads are never verified.
+	DisableSHA1Check bool
+
+	// HeaderOverrides, if not nil, makes B2 serve the download with the
+	// given response headers, which are reflected in the returned FileInfo.
+	HeaderOverrides *HeaderOverrides
+
+	// AuthorizationToken, if not "", is used instead of the Client one.
+	// Requests that fail with it are not retried after logging in again.
+	//
+	// It can be a token from GetDownloadAuthorization, but B2 accepts those
+	// only for downloads by name. Since OpenFile and the parallel downloads
+	// fetch ranges by file ID, they don't work with such tokens, and
+	// downloads made with them don't resume after a connection failure.
+	AuthorizationToken string
+}
+
+// rangeHeader returns the value of the Range header selected by opts, or ""
+// if the whole file is to be downloaded.
+func (opts *DownloadOptions) rangeHeader() (string, error) {
+	switch {
+	case opts.Length < 0:
+		return "", errors.New("b2: negative download Length")
+	case opts.Offset < 0 && opts.Length != 0:
+		return "", errors.New("b2: download Length set with a negative Offset")
+	case opts.Offset < 0:
+		return fmt.Sprintf("bytes=%d", opts.Offset), nil
+	case opts.Length > 0:
+		return fmt.Sprintf("bytes=%d-%d", opts.Offset, opts.Offset+opts.Length-1), nil
+	case opts.Offset > 0:
+		return fmt.Sprintf("bytes=%d-", opts.Offset), nil
+	}
+	return "", nil
+}
+
+// DownloadFileByIDWithOptions is like DownloadFileByID, but accepts
+// additional options.
+func (c *Client) DownloadFileByIDWithOptions(id string, opts *DownloadOptions) (io.ReadCloser, *FileInfo, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	res, err := c.download(apiPath+"b2_download_file_by_id?fileId="+id, opts)
+	if err != nil {
+		debugf("download %s: %s", id, err)
+		return nil, nil, err
+	}
+	debugf("download %s (%s)", id, res.Header.Get("X-Bz-Content-Sha1"))
+	return c.downloadResult(res, opts)
+}
+
+// DownloadFileByNameWithOptions is like DownloadFileByName, but accepts
+// additional options.
+func (c *Client) DownloadFileByNameWithOptions(bucket, file string, opts *DownloadOptions) (io.ReadCloser, *FileInfo, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
 	}
+	res, err := c.download("/file/"+bucket+"/"+escapePath(file), opts)
 	if err != nil {
 		debugf("download %s: %s", file, err)
 		return nil, nil, err
 	}
 	debugf("download %s (%s)", file, res.Header.Get("X-Bz-Content-Sha1"))
+	return c.downloadResult(res, opts)
+}
 
+// downloadResult parses the FileInfo of a download response, and wraps its
+// body according to opts.
+func (c *Client) downloadResult(res *http.Response, opts *DownloadOptions) (io.ReadCloser, *FileInfo, error) {
 	fi, err := parseFileInfoHeaders(res.Header)
-	return res.Body, fi, err
+	if err != nil {
+		drainAndClose(res.Body)
+		return nil, nil, err
+	}
+	var body io.ReadCloser
+	if body, err = c.newResumingReader(res, fi, opts); err != nil {
+		drainAndClose(res.Body)
+		return nil, nil, err
+	}
+	if !opts.DisableSHA1Check && res.StatusCode == http.StatusOK && expectedSHA1(fi) != "" {
+		body = sha1CheckReadCloser{&sha1CheckReader{r: body, fi: fi, h: sha1.New()}, body}
+	}
+	if r := limitReader(body, &c.downloadLimiter, opts.RateLimiter); r != io.Reader(body) {
+		body = rateLimitedReadCloser{r, body}
+	}
+	if t := newProgressTracker(opts.Progress, opts.ProgressInterval, res.ContentLength); t != nil {
+		body = progressReadCloser{t.reader(body), body}
+	}
+	return body, fi, nil
+}
+
+// download performs a GET for path on the download URL, logging in again
+// if the authorization token expired.
+func (c *Client) download(path string, opts *DownloadOptions) (*http.Response, error) {
+	return c.downloadRequest("GET", path, opts)
+}
+
+func (c *Client) downloadRequest(method, path string, opts *DownloadOptions) (*http.Response, error) {
+	if sse := opts.ServerSideEncryption; sse != nil {
+		if err := sse.check(); err != nil {
+			return nil, err
+		}
+	}
+	rangeHeader, err := opts.rangeHeader()
+	if err != nil {
+		return nil, err
+	}
+	if q := opts.HeaderOverrides.query(); len(q) > 0 {
+		if strings.Contains(path, "?") {
+			path += "&" + q.Encode()
+		} else {
+			path += "?" + q.Encode()
+		}
+	}
+	get := func() (*http.Response, error) {
+		downloadURL := c.loginInfo.Load().(*LoginInfo).DownloadURL
+		req, err := http.NewRequest(method, downloadURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if opts.AuthorizationToken != "" {
+			req.Header.Set("Authorization", opts.AuthorizationToken)
+		}
+		// Files uploaded with a Content-Encoding are served as stored. Setting
+		// Accept-Encoding prevents the Transport from transparently decoding
+		// them, which would break Content-Length and the SHA1.
+		req.Header.Set("Accept-Encoding", "identity")
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		opts.ServerSideEncryption.setCustomerKeyHeaders(req.Header)
+		return c.hc.Do(req)
+	}
+	res, err := get()
+	if e, ok := UnwrapError(err); ok && e.Status == http.StatusUnauthorized && opts.AuthorizationToken == "" {
+		if err = c.login(res); err == nil {
+			res, err = get()
+		}
+	}
+	return res, checkEncryptionKeyError(err, opts.ServerSideEncryption)
+}
+
+// HeadFileByName obtains the FileInfo of the latest version of the named file
+// with a HEAD request on its download URL, which unlike GetFileInfoByName
+// doesn't need a listing, and works with keys restricted to reading files.
+// The range options in opts are ignored.
+//
+// If the file doesn't exist, FileNotFoundError is returned.
+func (c *Client) HeadFileByName(bucket, file string, opts *DownloadOptions) (*FileInfo, error) {
+	o := DownloadOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Offset, o.Length = 0, 0
+	res, err := c.downloadRequest("HEAD", "/file/"+bucket+"/"+escapePath(file), &o)
+	if e, ok := UnwrapError(err); ok && e.Status == http.StatusNotFound {
+		return nil, FileNotFoundError
+	}
+	if err != nil {
+		debugf("head %s: %s", file, err)
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	debugf("head %s (%s)", file, res.Header.Get("X-Bz-File-Id"))
+	return parseFileInfoHeaders(res.Header)
+}
+
+// downloadHead downloads at most the first length bytes of path, to learn
+// the FileInfo and ID of the current version. Since empty files can't satisfy
+// any range, they are downloaded whole.
+func (c *Client) downloadHead(path string, opts DownloadOptions, length int64) (io.ReadCloser, *FileInfo, error) {
+	opts.Offset, opts.Length = 0, length
+	res, err := c.download(path, &opts)
+	if e, ok := UnwrapError(err); ok && e.Status == http.StatusRequestedRangeNotSatisfiable {
+		opts.Offset, opts.Length = 0, 0
+		res, err = c.download(path, &opts)
+	}
+	if err != nil {
+		debugf("download %s: %s", path, err)
+		return nil, nil, err
+	}
+	return c.downloadResult(res, &opts)
 }
 
 func parseFileInfoHeaders(h http.Header) (*FileInfo, error) {
@@ -67,18 +270,153 @@ func parseFileInfoHeaders(h http.Header) (*FileInfo, error) {
 		return nil, err
 	}
 	fi.UploadTimestamp = time.Unix(timestamp/1e3, timestamp%1e3*1e6)
-	fi.ContentLength, err = strconv.Atoi(h.Get("Content-Length"))
+	if cr := h.Get("Content-Range"); cr != "" {
+		var size int64
+		_, _, size, err = parseContentRange(cr)
+		fi.ContentLength = int(size)
+	} else {
+		fi.ContentLength, err = strconv.Atoi(h.Get("Content-Length"))
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	fi.CustomMetadata = make(map[string]interface{})
-	for name := range h {
-		if !strings.HasPrefix(name, "X-Bz-Info-") {
-			continue
+	fi.CustomMetadata = parseInfoHeaders(h)
+
+	fi.ContentDisposition = h.Get("Content-Disposition")
+	fi.ContentLanguage = h.Get("Content-Language")
+	fi.Expires = h.Get("Expires")
+	fi.CacheControl = h.Get("Cache-Control")
+	fi.ContentEncoding = h.Get("Content-Encoding")
+	fi.ServerSideEncryption = parseSSEHeaders(h)
+
+	return fi, nil
+}
+
+// parseContentRange parses a Content-Range header like "bytes 0-99/1234".
+func parseContentRange(cr string) (start, end, size int64, err error) {
+	if _, err := fmt.Sscanf(cr, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return 0, 0, 0, fmt.Errorf("b2: malformed Content-Range %q", cr)
+	}
+	return start, end, size, nil
+}
+
+// resumingReader reads a download body, and if it fails before the end, it
+// continues from the same offset with a new ranged request for the same file
+// ID, up to maxAttempts times.
+type resumingReader struct {
+	c    *Client
+	opts DownloadOptions // for the new requests
+	id   string
+	body io.ReadCloser
+	off  int64 // file offset of the next byte of body
+	end  int64 // file offset of the last byte
+	left int   // attempts left
+	err  error // sticky, once resuming failed
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for {
+		n, err := r.body.Read(p)
+		r.off += int64(n)
+		if err == nil || err == io.EOF || r.off > r.end {
+			return n, err
+		}
+		debugf("download %s: resuming at %d after %s", r.id, r.off, err)
+		r.body.Close()
+		if r.resume() != nil {
+			r.err = err
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
 		}
-		fi.CustomMetadata[name[len("X-Bz-Info-"):]] = h.Get(name)
 	}
+}
 
-	return fi, nil
+// resume replaces body with a request for the rest of the range.
+func (r *resumingReader) resume() error {
+	var err error
+	for r.left > 0 {
+		r.left--
+		o := r.opts
+		o.Offset, o.Length = r.off, r.end+1-r.off
+		var res *http.Response
+		res, err = r.c.download(apiPath+"b2_download_file_by_id?fileId="+r.id, &o)
+		if err == nil {
+			err = r.checkRange(res)
+		}
+		if err == nil {
+			r.body = res.Body
+			return nil
+		}
+		debugf("download %s: resume at %d: %s", r.id, r.off, err)
+	}
+	r.body = ioutil.NopCloser(strings.NewReader(""))
+	if err == nil {
+		err = errors.New("b2: too many attempts")
+	}
+	return err
+}
+
+// checkRange verifies that res is a partial response starting at r.off, as
+// the body of a server that ignored the Range header would duplicate data.
+func (r *resumingReader) checkRange(res *http.Response) error {
+	cr := res.Header.Get("Content-Range")
+	if res.StatusCode != http.StatusPartialContent || cr == "" {
+		drainAndClose(res.Body)
+		return fmt.Errorf("b2: resumed download got status %d without a range", res.StatusCode)
+	}
+	start, _, _, err := parseContentRange(cr)
+	if err == nil && start != r.off {
+		err = fmt.Errorf("b2: resumed download starts at %d, expected %d", start, r.off)
+	}
+	if err != nil {
+		drainAndClose(res.Body)
+	}
+	return err
+}
+
+func (r *resumingReader) Close() error {
+	return r.body.Close()
+}
+
+// newResumingReader wraps the body of res, the response to a request made
+// with opts for the file described by fi.
+func (c *Client) newResumingReader(res *http.Response, fi *FileInfo, opts *DownloadOptions) (*resumingReader, error) {
+	r := &resumingReader{
+		c: c,
+		opts: DownloadOptions{
+			ServerSideEncryption: opts.ServerSideEncryption,
+			HeaderOverrides:      opts.HeaderOverrides,
+			AuthorizationToken:   opts.AuthorizationToken,
+		},
+		id:   fi.ID,
+		body: res.Body,
+		end:  int64(fi.ContentLength) - 1,
+		left: maxAttempts - 1,
+	}
+	if opts.AuthorizationToken != "" {
+		// The token might not be valid for downloads by ID.
+		r.left = 0
+	}
+	cr := res.Header.Get("Content-Range")
+	if cr == "" {
+		if rh, _ := opts.rangeHeader(); rh != "" {
+			return nil, errors.New("b2: ranged download returned the whole file")
+		}
+		return r, nil
+	}
+	start, end, _, err := parseContentRange(cr)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Offset > 0 && start != opts.Offset {
+		return nil, fmt.Errorf("b2: ranged download starts at %d, expected %d", start, opts.Offset)
+	}
+	r.off, r.end = start, end
+	return r, nil
 }
@@ -26,15 +26,29 @@ type FileInfo struct {
 	// Had to remove BucketID since it is not returned by b2_download_file_by_*
 	// BucketID string
 
-	ContentSHA1   string // hex encoded
+	ContentSHA1   string // hex encoded, might be prefixed by "unverified:"
 	ContentLength int
 	ContentType   string
 
 	CustomMetadata  map[string]interface{}
 	UploadTimestamp time.Time
 
+	// ContentDisposition, ContentLanguage, Expires, CacheControl and
+	// ContentEncoding are the HTTP headers set at upload time with
+	// UploadOptions, returned when the file is downloaded.
+	ContentDisposition string
+	ContentLanguage    string
+	Expires            string
+	CacheControl       string
+	ContentEncoding    string
+
+	// ServerSideEncryption is the encryption mode of the file, or nil
+	// if it's not encrypted. The CustomerKey is never set.
+	ServerSideEncryption *ServerSideEncryption
+
 	// If Action is "hide", this ID does not refer to a file version
-	// but to an hiding action. Otherwise "upload".
+	// but to an hiding action. If Action is "start", it refers to an
+	// unfinished large file. Otherwise "upload".
 	Action string
 }
 
@@ -49,9 +63,15 @@ type fileInfoObj struct {
 	FileName        string                 `json:"fileName"`
 	UploadTimestamp int64                  `json:"uploadTimestamp"`
 	Action          string                 `json:"action"`
+
+	ServerSideEncryption *sseObj `json:"serverSideEncryption"`
 }
 
 func (fi *fileInfoObj) makeFileInfo() *FileInfo {
+	info := func(key string) string {
+		s, _ := fi.FileInfo[key].(string)
+		return s
+	}
 	return &FileInfo{
 		ID:              fi.FileID,
 		Name:            fi.FileName,
@@ -61,6 +81,14 @@ func (fi *fileInfoObj) makeFileInfo() *FileInfo {
 		CustomMetadata:  fi.FileInfo,
 		Action:          fi.Action,
 		UploadTimestamp: time.Unix(fi.UploadTimestamp/1e3, fi.UploadTimestamp%1e3*1e6),
+
+		ContentDisposition: info("b2-content-disposition"),
+		ContentLanguage:    info("b2-content-language"),
+		Expires:            info("b2-expires"),
+		CacheControl:       info("b2-cache-control"),
+		ContentEncoding:    info("b2-content-encoding"),
+
+		ServerSideEncryption: fi.ServerSideEncryption.makeServerSideEncryption(),
 	}
 }
 
@@ -84,11 +112,30 @@ func (c *Client) GetFileInfoByID(id string) (*FileInfo, error) {
 
 var FileNotFoundError = errors.New("no file with the given name in the bucket")
 
-// GetFileInfoByName obtains a FileInfo for a given name.
+// LookupStrategy selects how (*Bucket).GetFileInfoByName finds a file.
+type LookupStrategy int
+
+const (
+	// LookupByListing uses b2_list_file_names, a class C transaction
+	// which requires the listFiles capability.
+	LookupByListing LookupStrategy = iota
+
+	// LookupByHead uses a HEAD request on the download URL, like
+	// (*Client).HeadFileByName. It requires the bucket name, so it can
+	// only be selected with BucketOptions.Name. The FileInfo
+	// CustomMetadata values are all strings, as they come from headers.
+	LookupByHead
+)
+
+// GetFileInfoByName obtains a FileInfo for a given name, with the
+// LookupStrategy selected by BucketByIDWithOptions, or by listing.
 //
 // If the file doesn't exist, FileNotFoundError is returned.
 // If multiple versions of the file exist, only the latest is returned.
 func (b *Bucket) GetFileInfoByName(name string) (*FileInfo, error) {
+	if b.lookup == LookupByHead {
+		return b.c.HeadFileByName(b.name, name, nil)
+	}
 	l := b.ListFiles(name)
 	l.SetPageCount(1)
 	if l.Next() {
@@ -6,8 +6,13 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -193,3 +198,684 @@ func TestFileListing(t *testing.T) {
 		t.Errorf("got %d files, expected %d", i-1, len(fileIDs)-1+2)
 	}
 }
+
+func TestServerSideEncryption(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	file := make([]byte, 1234)
+	rand.Read(file)
+
+	for _, sse := range []*b2.ServerSideEncryption{
+		{Mode: b2.SSEB2},
+		{Mode: b2.SSEC, CustomerKey: key},
+	} {
+		fiu, err := b.UploadWithOptions(bytes.NewReader(file), "test-sse", &b2.UploadOptions{
+			ServerSideEncryption: sse,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.DeleteFile(fiu.ID, fiu.Name)
+
+		fi, err := c.GetFileInfoByID(fiu.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.ServerSideEncryption == nil || fi.ServerSideEncryption.Mode != sse.Mode {
+			t.Errorf("wrong encryption mode: %+v", fi.ServerSideEncryption)
+		}
+
+		rc, fi, err := c.DownloadFileByIDWithOptions(fiu.ID, &b2.DownloadOptions{
+			ServerSideEncryption: sse,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, file) {
+			t.Error("mismatch in file contents")
+		}
+		if fi.ServerSideEncryption == nil || fi.ServerSideEncryption.Mode != sse.Mode {
+			t.Errorf("wrong encryption mode in download: %+v", fi.ServerSideEncryption)
+		}
+
+		if sse.Mode == b2.SSEC {
+			if _, _, err := c.DownloadFileByID(fiu.ID); err == nil {
+				t.Error("download without key succeeded")
+			} else if _, ok := err.(*b2.EncryptionKeyError); !ok {
+				t.Errorf("expected an EncryptionKeyError, got %T: %v", err, err)
+			}
+			wrongKey := make([]byte, 32)
+			_, _, err := c.DownloadFileByIDWithOptions(fiu.ID, &b2.DownloadOptions{
+				ServerSideEncryption: &b2.ServerSideEncryption{Mode: b2.SSEC, CustomerKey: wrongKey},
+			})
+			if _, ok := err.(*b2.EncryptionKeyError); !ok {
+				t.Errorf("expected an EncryptionKeyError, got %T: %v", err, err)
+			}
+			if e, ok := b2.UnwrapError(err); !ok || e.Status == 0 {
+				t.Errorf("UnwrapError failed on %v", err)
+			}
+		}
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	ssec := &b2.ServerSideEncryption{Mode: b2.SSEC, CustomerKey: key}
+	file := make([]byte, 1234)
+	rand.Read(file)
+	fiu, err := b.UploadWithOptions(bytes.NewReader(file), "test-copy-src", &b2.UploadOptions{
+		ServerSideEncryption: ssec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	if _, err := b.CopyFile(fiu.ID, "test-copy-nokey", nil); err == nil {
+		t.Error("copy of SSE-C file without key succeeded")
+	}
+
+	fic, err := b.CopyFile(fiu.ID, "test-copy-dst", &b2.CopyOptions{
+		Offset: 100, Length: 200,
+		ReplaceMetadata:            true,
+		ContentType:                "application/octet-stream",
+		Info:                       map[string]string{"foo": "bar"},
+		SourceServerSideEncryption: ssec,
+		ServerSideEncryption:       &b2.ServerSideEncryption{Mode: b2.SSEB2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fic.ID, fic.Name)
+	if fic.ContentLength != 200 || fic.CustomMetadata["foo"] != "bar" {
+		t.Errorf("wrong copy info: %+v", fic)
+	}
+	if fic.ServerSideEncryption == nil || fic.ServerSideEncryption.Mode != b2.SSEB2 {
+		t.Errorf("wrong encryption mode: %+v", fic.ServerSideEncryption)
+	}
+
+	rc, _, err := c.DownloadFileByID(fic.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, file[100:300]) {
+		t.Error("mismatch in copied contents")
+	}
+}
+
+func TestDownloadByNameEscaping(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 1234)
+	rand.Read(file)
+	name := "test dir/foo?bar#baz%20+.txt"
+	fiu, err := b.Upload(bytes.NewReader(file), name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	rc, fi, err := c.DownloadFileByName(b.Name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ID != fiu.ID || !bytes.Equal(body, file) {
+		t.Error("mismatched download by name", fi.ID)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 123456)
+	rand.Read(file)
+	fiu, err := b.Upload(bytes.NewReader(file), "test-foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	for _, tc := range []struct {
+		offset, length int64
+		want           []byte
+	}{
+		{0, 100, file[:100]},
+		{1000, 234, file[1000:1234]},
+		{123000, 0, file[123000:]},
+		{-56, 0, file[len(file)-56:]},
+	} {
+		rc, fi, err := c.DownloadFileByIDWithOptions(fiu.ID, &b2.DownloadOptions{
+			Offset: tc.offset, Length: tc.length,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, tc.want) {
+			t.Errorf("range %d+%d: mismatch in contents", tc.offset, tc.length)
+		}
+		if fi.ContentLength != len(file) {
+			t.Errorf("range %d+%d: ContentLength is %d", tc.offset, tc.length, fi.ContentLength)
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 123456)
+	rand.Read(file)
+	fiu, err := b.Upload(bytes.NewReader(file), "test-foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	f, err := c.OpenFile(b.Name, "test-foo", &b2.OpenFileOptions{
+		BlockSize: 10000, CacheBlocks: 4, Readahead: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.FileInfo().ID != fiu.ID || f.Size() != int64(len(file)) {
+		t.Fatal("mismatched FileInfo", f.FileInfo().ID, f.Size())
+	}
+
+	// A new version must not affect the open file.
+	fi2, err := b.Upload(bytes.NewReader([]byte("new version")), "test-foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi2.ID, fi2.Name)
+
+	buf := make([]byte, 25000)
+	if n, err := f.ReadAt(buf, 5000); err != nil || n != len(buf) {
+		t.Fatal(n, err)
+	}
+	if !bytes.Equal(buf, file[5000:30000]) {
+		t.Error("mismatch in ReadAt contents")
+	}
+	if n, err := f.ReadAt(buf, 110000); err != io.EOF || n != 13456 {
+		t.Error("expected a short read at the end:", n, err)
+	}
+
+	if _, err := f.Seek(-1000, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, file[len(file)-1000:]) {
+		t.Error("mismatch in Read contents")
+	}
+}
+
+func TestParallelDownload(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 123456)
+	rand.Read(file)
+	fiu, err := b.Upload(bytes.NewReader(file), "test-foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	tmpfile, err := ioutil.TempFile("", "b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	fi, err := c.ParallelDownloadFileByName(b.Name, "test-foo", tmpfile, &b2.ParallelDownloadOptions{
+		PartSize: 10000, Concurrency: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ID != fiu.ID {
+		t.Error("mismatched file ID")
+	}
+	body, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, file) {
+		t.Error("mismatch in file contents")
+	}
+}
+
+func TestDownloadParallel(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 123456)
+	rand.Read(file)
+	fiu, err := b.Upload(bytes.NewReader(file), "test-foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	rc, fi, err := c.DownloadFileByIDParallel(fiu.ID, &b2.ParallelDownloadOptions{
+		PartSize: 10000, Concurrency: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if fi.ContentLength != len(file) {
+		t.Error("mismatched fi.ContentLength", fi.ContentLength)
+	}
+	body, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, file) {
+		t.Error("mismatch in file contents")
+	}
+}
+
+func TestDownloadAuthorization(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 1234)
+	rand.Read(file)
+	fiu, err := b.Upload(bytes.NewReader(file), "shared/test foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	overrides := &b2.HeaderOverrides{ContentDisposition: "attachment"}
+	da, err := b.GetDownloadAuthorization("shared/", time.Minute, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if da.FileNamePrefix != "shared/" {
+		t.Error("mismatched FileNamePrefix", da.FileNamePrefix)
+	}
+
+	res, err := http.Get(c.DownloadURLByName(b.Name, "shared/test foo", da.AuthorizationToken, overrides))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body, file) {
+		t.Fatal("download with authorization failed", res.Status)
+	}
+	if cd := res.Header.Get("Content-Disposition"); cd != "attachment" {
+		t.Error("missing Content-Disposition override", cd)
+	}
+
+	res, err = http.Get(c.DownloadURLByName(b.Name, "shared/test foo", "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		t.Error("download from a private bucket without authorization succeeded")
+	}
+}
+
+func TestHeadFileByName(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 1234)
+	rand.Read(file)
+	fiu, err := b.Upload(bytes.NewReader(file), "test-foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	fi, err := c.HeadFileByName(b.Name, "test-foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ID != fiu.ID || fi.ContentLength != len(file) || fi.ContentSHA1 != fiu.ContentSHA1 {
+		t.Error("mismatched FileInfo", fi)
+	}
+	if _, err := c.HeadFileByName(b.Name, "not-exists", nil); err != b2.FileNotFoundError {
+		t.Errorf("HeadFileByName did not return FileNotFoundError: %v", err)
+	}
+
+	if _, err := c.BucketByIDWithOptions(b.ID, &b2.BucketOptions{
+		LookupStrategy: b2.LookupByHead,
+	}); err == nil {
+		t.Error("LookupByHead without a bucket name was accepted")
+	}
+	hb, err := c.BucketByIDWithOptions(b.ID, &b2.BucketOptions{
+		Name: b.Name, LookupStrategy: b2.LookupByHead,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err = hb.GetFileInfoByName("test-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ID != fiu.ID {
+		t.Error("mismatched file ID in GetFileInfoByName")
+	}
+	if _, err := hb.GetFileInfoByName("not-exists"); err != b2.FileNotFoundError {
+		t.Errorf("GetFileInfoByName did not return FileNotFoundError: %v", err)
+	}
+}
+
+func TestDownloadOverrides(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 1234)
+	rand.Read(file)
+	fiu, err := b.Upload(bytes.NewReader(file), "test foo?", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fiu.ID, fiu.Name)
+
+	overrides := &b2.HeaderOverrides{
+		ContentDisposition: "attachment; filename=foo.bin",
+		ContentType:        "application/x-foo",
+	}
+	da, err := b.GetDownloadAuthorization("test", time.Minute, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, fi, err := c.DownloadFileByNameWithOptions(b.Name, "test foo?", &b2.DownloadOptions{
+		HeaderOverrides:    overrides,
+		AuthorizationToken: da.AuthorizationToken,
+		Offset:             100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, file[100:]) {
+		t.Error("mismatch in file contents")
+	}
+	if fi.ContentDisposition != overrides.ContentDisposition || fi.ContentType != overrides.ContentType {
+		t.Error("headers not overridden", fi.ContentDisposition, fi.ContentType)
+	}
+}
+
+// cuttingTransport fakes B2 for downloads by ID of content, without network,
+// failing every response body after cut bytes. If ignoreRange is set, it
+// always returns the whole file, like a misbehaving proxy.
+type cuttingTransport struct {
+	content     []byte
+	cut         int
+	ignoreRange bool
+
+	mu       sync.Mutex
+	requests int // download requests
+}
+
+func (t *cuttingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Request:    r,
+	}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/b2_authorize_account"):
+		res.Body = ioutil.NopCloser(strings.NewReader(`{"accountId": "test",
+			"apiUrl": "https://api.invalid", "downloadUrl": "https://download.invalid",
+			"authorizationToken": "test"}`))
+		return res, nil
+	case strings.HasSuffix(r.URL.Path, "/b2_download_file_by_id"):
+	default:
+		return nil, fmt.Errorf("unexpected request to %s", r.URL)
+	}
+
+	t.mu.Lock()
+	t.requests++
+	t.mu.Unlock()
+
+	if id := r.URL.Query().Get("fileId"); id != "test-id" {
+		res.StatusCode = http.StatusBadRequest
+		res.Body = ioutil.NopCloser(strings.NewReader(`{"code": "bad_request",
+			"message": "Invalid fileId: ` + id + `", "status": 400}`))
+		return res, nil
+	}
+
+	start, end := 0, len(t.content)-1
+	if rh := r.Header.Get("Range"); rh != "" && !t.ignoreRange {
+		if _, err := fmt.Sscanf(rh, "bytes=%d-%d", &start, &end); err != nil {
+			return nil, err
+		}
+		res.StatusCode = http.StatusPartialContent
+		res.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(t.content)))
+	}
+	digest := sha1.Sum(t.content)
+	res.Header.Set("X-Bz-File-Id", "test-id")
+	res.Header.Set("X-Bz-File-Name", "test-file")
+	res.Header.Set("X-Bz-Content-Sha1", hex.EncodeToString(digest[:]))
+	res.Header.Set("X-Bz-Upload-Timestamp", "1500000000000")
+	res.Header.Set("X-Bz-Info-Raw", "a+b%20c") // as stored by another client
+	res.Header.Set("Content-Length", fmt.Sprint(end+1-start))
+	res.Body = ioutil.NopCloser(&cutReader{r: bytes.NewReader(t.content[start : end+1]), left: t.cut})
+	return res, nil
+}
+
+// cutReader fails with io.ErrUnexpectedEOF after left bytes.
+type cutReader struct {
+	r    io.Reader
+	left int
+}
+
+func (r *cutReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.r.Read(p)
+	r.left -= n
+	return n, err
+}
+
+// unlimited is a cut larger than any test content.
+const unlimited = 1 << 30
+
+func TestDownloadResume(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.Read(content)
+	ct := &cuttingTransport{content: content, cut: 30000}
+	c, err := b2.NewClient("test", "test", &http.Client{Transport: ct})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, _, err := c.DownloadFileByID("test-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err) // including an *IntegrityError from the SHA1 check
+	}
+	if !bytes.Equal(body, content) {
+		t.Error("mismatch in resumed contents")
+	}
+	if ct.requests != 4 {
+		t.Errorf("expected 4 requests, got %d", ct.requests)
+	}
+}
+
+func TestDownloadResumeLimit(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.Read(content)
+	ct := &cuttingTransport{content: content, cut: 10000}
+	c, err := b2.NewClient("test", "test", &http.Client{Transport: ct})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, _, err := c.DownloadFileByID("test-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if !bytes.Equal(body, content[:len(body)]) {
+		t.Error("mismatch in partial contents")
+	}
+	// The first request and maxAttempts-1 resumes.
+	if ct.requests != 5 || len(body) != 50000 {
+		t.Errorf("expected 5 requests and 50000 bytes, got %d and %d", ct.requests, len(body))
+	}
+}
+
+func TestDownloadBadRequest(t *testing.T) {
+	ct := &cuttingTransport{content: make([]byte, 1000), cut: unlimited}
+	c, err := b2.NewClient("test", "test", &http.Client{Transport: ct})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = c.DownloadFileByID("bogus")
+	if _, ok := err.(*b2.EncryptionKeyError); ok {
+		t.Errorf("invalid file ID reported as a key error: %v", err)
+	}
+	if e, ok := b2.UnwrapError(err); !ok || e.Code != "bad_request" {
+		t.Errorf("expected a bad_request error, got %T: %v", err, err)
+	}
+}
+
+func TestDownloadIgnoredRange(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.Read(content)
+	ct := &cuttingTransport{content: content, cut: unlimited, ignoreRange: true}
+	c, err := b2.NewClient("test", "test", &http.Client{Transport: ct})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := c.DownloadFileByIDWithOptions("test-id", &b2.DownloadOptions{
+		Offset: 1000, Length: 1000,
+	}); err == nil {
+		t.Error("ranged download accepted the whole file")
+	}
+
+	// Resumes get the whole file again, and must not append it.
+	ct.cut = 30000
+	rc, _, err := c.DownloadFileByIDWithOptions("test-id", &b2.DownloadOptions{
+		DisableSHA1Check: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if !bytes.Equal(body, content[:30000]) {
+		t.Errorf("got %d bytes instead of the first 30000", len(body))
+	}
+}
+
+func TestDownloadParallelRetries(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.Read(content)
+	ct := &cuttingTransport{content: content, cut: 5000}
+	c, err := b2.NewClient("test", "test", &http.Client{Transport: ct})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each 30000 bytes part needs 6 requests, one more than a body resumes.
+	if _, _, err := c.DownloadFileByIDParallel("test-id", &b2.ParallelDownloadOptions{
+		PartSize: 30000,
+	}); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if ct.requests != 5 {
+		t.Errorf("expected 5 requests, got %d", ct.requests)
+	}
+
+	ct.requests = 0
+	if _, _, err := c.DownloadFileByIDParallel("bogus", nil); err == nil {
+		t.Error("download of a bogus ID succeeded")
+	}
+	if ct.requests != 1 {
+		t.Errorf("expected 1 request, got %d", ct.requests)
+	}
+}
+
+func TestDownloadInfoHeaders(t *testing.T) {
+	ct := &cuttingTransport{content: make([]byte, 1000), cut: unlimited}
+	c, err := b2.NewClient("test", "test", &http.Client{Transport: ct})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, fi, err := c.DownloadFileByID("test-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if fi.CustomMetadata["raw"] != "a+b c" {
+		t.Errorf("wrong raw info: %q", fi.CustomMetadata["raw"])
+	}
+}
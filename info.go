@@ -0,0 +1,88 @@
+package b2
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// B2 limits on custom file information.
+const (
+	maxInfoEntries    = 10
+	maxInfoKeyLength  = 50
+	maxInfoHeaderSize = 7000
+)
+
+// checkFileInfo validates the custom file information against the B2 limits,
+// and returns a copy with lowercased keys, since B2 treats them as
+// case-insensitive and returns them lowercased.
+func checkFileInfo(info map[string]string) (map[string]string, error) {
+	if len(info) > maxInfoEntries {
+		return nil, fmt.Errorf("too many file info entries: %d, maximum is %d", len(info), maxInfoEntries)
+	}
+	res := make(map[string]string, len(info))
+	size := 0
+	for k, v := range info {
+		if len(k) == 0 || len(k) > maxInfoKeyLength {
+			return nil, fmt.Errorf("invalid file info key %q: length must be 1 to %d", k, maxInfoKeyLength)
+		}
+		for _, c := range k {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return nil, fmt.Errorf("invalid file info key %q: only letters, numbers, '-' and '_' are allowed", k)
+			}
+		}
+		k = strings.ToLower(k)
+		if _, ok := res[k]; ok {
+			return nil, fmt.Errorf("duplicate file info key %q", k)
+		}
+		res[k] = v
+		size += len(infoHeaderPrefix) + len(k) + len(escapeInfoValue(v))
+	}
+	if size > maxInfoHeaderSize {
+		return nil, fmt.Errorf("file info too large: %d bytes of headers, maximum is %d", size, maxInfoHeaderSize)
+	}
+	return res, nil
+}
+
+const infoHeaderPrefix = "X-Bz-Info-"
+
+// setInfoHeaders adds the X-Bz-Info-* headers for info to h.
+func setInfoHeaders(h http.Header, info map[string]string) {
+	for k, v := range info {
+		h.Set(infoHeaderPrefix+k, escapeInfoValue(v))
+	}
+}
+
+// escapeInfoValue percent-encodes a header value as required by B2, leaving
+// only unreserved characters unescaped. Unlike url.QueryEscape, it never
+// encodes spaces as '+'.
+func escapeInfoValue(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/' {
+			b = append(b, c)
+		} else {
+			b = append(b, fmt.Sprintf("%%%02X", c)...)
+		}
+	}
+	return string(b)
+}
+
+// parseInfoHeaders decodes the X-Bz-Info-* headers in h.
+func parseInfoHeaders(h http.Header) map[string]interface{} {
+	info := make(map[string]interface{})
+	for name := range h {
+		if !strings.HasPrefix(name, infoHeaderPrefix) {
+			continue
+		}
+		value := h.Get(name)
+		if v, err := url.PathUnescape(value); err == nil {
+			value = v
+		}
+		info[strings.ToLower(name[len(infoHeaderPrefix):])] = value
+	}
+	return info
+}
@@ -0,0 +1,118 @@
+package b2
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// IntegrityError is returned when what B2 reports about a file or part does
+// not match what was sent or expected, for example because of a misbehaving
+// proxy. An upload that failed with an IntegrityError might still have
+// created a file version.
+type IntegrityError struct {
+	// Name is the name of the file.
+	Name string
+	// Part is the part number, or zero if the error is about the whole file.
+	Part int
+	// Field is what didn't match: "sha1", "length" or "name".
+	Field string
+
+	Expected, Got string
+}
+
+func (e *IntegrityError) Error() string {
+	what := fmt.Sprintf("%q", e.Name)
+	if e.Part != 0 {
+		what += fmt.Sprintf(" part %d", e.Part)
+	}
+	return fmt.Sprintf("b2: integrity check failed for %s: %s is %q, expected %q",
+		what, e.Field, e.Got, e.Expected)
+}
+
+// unverifiedPrefix can be prepended by B2 to a reported SHA1 it did not
+// compute itself, for example for large files with the large_file_sha1 info.
+// SHA1s sent after the body with HexDigitsAtEnd are verified by B2 and are
+// reported without it. The digits are still the SHA1 of the data as sent, so
+// every comparison in this package removes the prefix first, see plainSHA1.
+const unverifiedPrefix = "unverified:"
+
+// plainSHA1 returns the hex SHA1 sha1Sum without the unverifiedPrefix.
+func plainSHA1(sha1Sum string) string {
+	return strings.TrimPrefix(sha1Sum, unverifiedPrefix)
+}
+
+// checkUploadedFile verifies the FileInfo returned by an upload. An empty
+// sha1Sum is not checked, as B2 doesn't report the SHA1 of large files.
+func checkUploadedFile(fi *FileInfo, name, sha1Sum string, length int64) error {
+	if fi.Name != name {
+		return &IntegrityError{Name: name, Field: "name", Expected: name, Got: fi.Name}
+	}
+	if int64(fi.ContentLength) != length {
+		return &IntegrityError{Name: name, Field: "length",
+			Expected: strconv.FormatInt(length, 10), Got: strconv.Itoa(fi.ContentLength)}
+	}
+	if sha1Sum != "" && plainSHA1(fi.ContentSHA1) != sha1Sum {
+		return &IntegrityError{Name: name, Field: "sha1", Expected: sha1Sum, Got: fi.ContentSHA1}
+	}
+	return nil
+}
+
+// checkUploadedPart verifies the PartInfo returned by a part upload.
+func checkUploadedPart(pi *PartInfo, name string, number int, sha1Sum string, length int64) error {
+	if pi.ContentLength != length {
+		return &IntegrityError{Name: name, Part: number, Field: "length",
+			Expected: strconv.FormatInt(length, 10), Got: strconv.FormatInt(pi.ContentLength, 10)}
+	}
+	if plainSHA1(pi.ContentSHA1) != sha1Sum {
+		return &IntegrityError{Name: name, Part: number, Field: "sha1", Expected: sha1Sum, Got: pi.ContentSHA1}
+	}
+	return nil
+}
+
+// expectedSHA1 returns the SHA1 of the whole file as reported by B2, or the
+// large_file_sha1 info for large files, or "" if neither is available.
+func expectedSHA1(fi *FileInfo) string {
+	sha1Sum := plainSHA1(fi.ContentSHA1)
+	if sha1Sum == "none" || sha1Sum == "" {
+		sha1Sum, _ = fi.CustomMetadata["large_file_sha1"].(string)
+	}
+	return sha1Sum
+}
+
+// checkDownloadedSHA1 compares the SHA1 of a whole downloaded file with the
+// one from expectedSHA1. If that's not available, nothing is checked.
+func checkDownloadedSHA1(fi *FileInfo, sha1Sum string) error {
+	expected := expectedSHA1(fi)
+	if expected == "" || expected == sha1Sum {
+		return nil
+	}
+	return &IntegrityError{Name: fi.Name, Field: "sha1", Expected: expected, Got: sha1Sum}
+}
+
+// sha1CheckReader computes the SHA1 of what's read from r, and at EOF
+// returns an *IntegrityError instead of io.EOF if it doesn't match fi.
+type sha1CheckReader struct {
+	r  io.Reader
+	fi *FileInfo
+	h  hash.Hash
+}
+
+func (r *sha1CheckReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF {
+		if err := checkDownloadedSHA1(r.fi, hex.EncodeToString(r.h.Sum(nil))); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+type sha1CheckReadCloser struct {
+	*sha1CheckReader
+	io.Closer
+}
@@ -0,0 +1,737 @@
+package b2
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A LargeFile is an unfinished large file, started with StartLargeFile.
+// Parts are uploaded with UploadPart, and the file is then assembled with
+// (*Client).FinishLargeFile or discarded with (*Client).CancelLargeFile.
+//
+// A LargeFile is safe for concurrent use, and parts can be uploaded in
+// parallel. Like Bucket, it reuses upload URLs across UploadPart calls.
+type LargeFile struct {
+	ID          string
+	Name        string
+	ContentType string
+
+	CustomMetadata  map[string]interface{}
+	UploadTimestamp time.Time
+
+	// ServerSideEncryption is the encryption mode of the file, or nil.
+	// For SSEC files, the CustomerKey must be set to upload parts. It's
+	// set by StartLargeFileWithOptions, but not by ListUnfinishedLargeFiles.
+	ServerSideEncryption *ServerSideEncryption
+
+	c *Client
+
+	partURLs uploadURLPool
+}
+
+func (fi *fileInfoObj) makeLargeFile(c *Client) *LargeFile {
+	return &LargeFile{
+		ID:              fi.FileID,
+		Name:            fi.FileName,
+		ContentType:     fi.ContentType,
+		CustomMetadata:  fi.FileInfo,
+		UploadTimestamp: time.Unix(fi.UploadTimestamp/1e3, fi.UploadTimestamp%1e3*1e6),
+
+		ServerSideEncryption: fi.ServerSideEncryption.makeServerSideEncryption(),
+
+		c: c,
+	}
+}
+
+// StartLargeFile calls b2_start_large_file. If mimeType is "", "b2/x-auto"
+// will be used. fileInfo is stored as the custom metadata of the file, and
+// can be nil. It's subject to the same limits as UploadOptions.Info.
+//
+// The file is not visible in the bucket until FinishLargeFile is called.
+// Unfinished large files are listed by ListFilesVersions with Action "start".
+func (b *Bucket) StartLargeFile(name, mimeType string, fileInfo map[string]string) (*LargeFile, error) {
+	fileInfo, err := checkFileInfo(fileInfo)
+	if err != nil {
+		return nil, err
+	}
+	return b.startLargeFile(name, mimeType, fileInfo, nil)
+}
+
+// StartLargeFileWithOptions is like StartLargeFile, but takes the content
+// type, file info, headers and encryption from opts. The other options are
+// ignored.
+func (b *Bucket) StartLargeFileWithOptions(name string, opts *UploadOptions) (*LargeFile, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	info, err := opts.fileInfo()
+	if err != nil {
+		return nil, err
+	}
+	return b.startLargeFile(name, opts.ContentType, info, opts.ServerSideEncryption)
+}
+
+// startLargeFile calls b2_start_large_file with an already checked fileInfo.
+func (b *Bucket) startLargeFile(name, mimeType string, fileInfo map[string]string, sse *ServerSideEncryption) (*LargeFile, error) {
+	if mimeType == "" {
+		mimeType = "b2/x-auto"
+	}
+	params := map[string]interface{}{
+		"bucketId":    b.ID,
+		"fileName":    name,
+		"contentType": mimeType,
+	}
+	if len(fileInfo) > 0 {
+		params["fileInfo"] = fileInfo
+	}
+	if sse != nil {
+		if err := sse.check(); err != nil {
+			return nil, err
+		}
+		params["serverSideEncryption"] = sse.params()
+	}
+	res, err := b.c.doRequest("b2_start_large_file", params)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var fi fileInfoObj
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		return nil, err
+	}
+	lf := fi.makeLargeFile(b.c)
+	if sse != nil {
+		lf.ServerSideEncryption = sse
+	}
+	return lf, nil
+}
+
+// UploadPartURL is an upload URL obtained with b2_get_upload_part_url. It is
+// bound to a single large file, and can be used for one upload at a time.
+type UploadPartURL struct {
+	FileID, UploadURL, AuthorizationToken string
+}
+
+// GetUploadPartURL calls b2_get_upload_part_url to obtain a new URL for
+// uploading parts of lf.
+//
+// UploadPart manages a pool of URLs automatically, so this is only needed to
+// implement custom part uploads.
+func (lf *LargeFile) GetUploadPartURL() (*UploadPartURL, error) {
+	res, err := lf.c.doRequest("b2_get_upload_part_url", map[string]interface{}{
+		"fileId": lf.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var u *UploadPartURL
+	if err := json.NewDecoder(res.Body).Decode(&u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (lf *LargeFile) getUploadPartURL() (*pooledURL, error) {
+	if u := lf.partURLs.get(); u != nil {
+		return u, nil
+	}
+	u, err := lf.GetUploadPartURL()
+	if err != nil {
+		return nil, err
+	}
+	return &pooledURL{url: u.UploadURL, token: u.AuthorizationToken, obtained: time.Now()}, nil
+}
+
+// A PartInfo is the metadata associated with an uploaded part of a large file.
+type PartInfo struct {
+	FileID     string
+	PartNumber int
+
+	ContentLength int64
+	ContentSHA1   string // hex encoded, might be prefixed by "unverified:"
+
+	UploadTimestamp time.Time
+}
+
+type partInfoObj struct {
+	FileID          string `json:"fileId"`
+	PartNumber      int    `json:"partNumber"`
+	ContentLength   int64  `json:"contentLength"`
+	ContentSHA1     string `json:"contentSha1"`
+	UploadTimestamp int64  `json:"uploadTimestamp"`
+}
+
+func (pi *partInfoObj) makePartInfo() *PartInfo {
+	return &PartInfo{
+		FileID:          pi.FileID,
+		PartNumber:      pi.PartNumber,
+		ContentLength:   pi.ContentLength,
+		ContentSHA1:     pi.ContentSHA1,
+		UploadTimestamp: time.Unix(pi.UploadTimestamp/1e3, pi.UploadTimestamp%1e3*1e6),
+	}
+}
+
+// UploadPart uploads a part of lf with b2_upload_part. Part numbers start at 1,
+// and parts can be uploaded in any order and concurrently. All parts except the
+// last must be at least LoginInfo.AbsoluteMinimumPartSize bytes long.
+//
+// sha1Sum should be the hex encoding of the SHA1 sum of what will be read from r,
+// or HexDigitsAtEnd to have it computed while uploading and sent after the body.
+// In that case, the SHA1 to pass to FinishLargeFile is in the returned PartInfo.
+// If the length or SHA1 reported by B2 don't match what was sent, an
+// *IntegrityError is returned.
+//
+// Like UploadWithSHA1, UploadPart does not retry on failure, and retrying is
+// the responsibility of the caller. Upload URLs are pooled and reused like the
+// ones of a Bucket.
+func (lf *LargeFile) UploadPart(r io.Reader, partNumber int, sha1Sum string, length int64) (*PartInfo, error) {
+	return lf.uploadPart(r, partNumber, sha1Sum, length, &UploadOptions{})
+}
+
+func (lf *LargeFile) uploadPart(r io.Reader, partNumber int, sha1Sum string, length int64, opts *UploadOptions) (*PartInfo, error) {
+	uurl, err := lf.getUploadPartURL()
+	if err != nil {
+		return nil, err
+	}
+
+	rr := &readErrRecorder{r: limitReader(r, &lf.c.uploadLimiter, opts.RateLimiter)}
+	body, sentSHA1 := newUploadBody(rr, sha1Sum, length)
+	req, err := http.NewRequest("POST", uurl.url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = uploadContentLength(sha1Sum, length)
+	req.Header.Set("Authorization", uurl.token)
+	req.Header.Set("X-Bz-Part-Number", strconv.Itoa(partNumber))
+	req.Header.Set("X-Bz-Content-Sha1", sha1Sum)
+	lf.ServerSideEncryption.setCustomerKeyHeaders(req.Header)
+
+	res, err := lf.c.hc.Do(req)
+	if err != nil {
+		debugf("upload part %s #%d: %s", lf.Name, partNumber, err)
+		lf.partURLs.release(uurl, err, rr.error())
+		return nil, err
+	}
+	debugf("upload part %s #%d (%d %s)", lf.Name, partNumber, length, sha1Sum)
+	defer drainAndClose(res.Body)
+
+	pi := partInfoObj{}
+	err = json.NewDecoder(res.Body).Decode(&pi)
+	lf.partURLs.release(uurl, err, nil)
+	if err != nil {
+		return nil, err
+	}
+	p := pi.makePartInfo()
+	if err := checkUploadedPart(p, lf.Name, partNumber, sentSHA1(), length); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// FinishLargeFile calls b2_finish_large_file to assemble the uploaded parts
+// of the large file with the given ID into a regular file.
+//
+// partSHA1s must contain the hex encoded SHA1 of every part, in order.
+func (c *Client) FinishLargeFile(id string, partSHA1s []string) (*FileInfo, error) {
+	res, err := c.doRequest("b2_finish_large_file", map[string]interface{}{
+		"fileId":        id,
+		"partSha1Array": partSHA1s,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var fi fileInfoObj
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		return nil, err
+	}
+	return fi.makeFileInfo(), nil
+}
+
+// CancelLargeFile calls b2_cancel_large_file to discard an unfinished large
+// file and all the parts uploaded so far.
+func (c *Client) CancelLargeFile(id string) error {
+	res, err := c.doRequest("b2_cancel_large_file", map[string]interface{}{
+		"fileId": id,
+	})
+	if err != nil {
+		return err
+	}
+	drainAndClose(res.Body)
+	return nil
+}
+
+// defaultPartSize is the part size used if neither the options nor the
+// LoginInfo set one, which is the size B2 usually recommends.
+const defaultPartSize = 100 << 20
+
+// partSize returns the part size to use for a large file of the given size.
+// It's never zero, even if LoginInfo lacks the part sizes.
+func (c *Client) partSize(opts *UploadOptions, size int64) int64 {
+	li := c.loginInfo.Load().(*LoginInfo)
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = li.RecommendedPartSize
+	}
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < li.AbsoluteMinimumPartSize {
+		partSize = li.AbsoluteMinimumPartSize
+	}
+	// B2 allows at most 10000 parts.
+	if minSize := (size + 9999) / 10000; partSize < minSize {
+		partSize = minSize
+	}
+	return partSize
+}
+
+func (opts *UploadOptions) largeFileThreshold(partSize int64) int64 {
+	if opts.LargeFileThreshold != 0 {
+		return opts.LargeFileThreshold
+	}
+	return 2 * partSize
+}
+
+type largeFilePart struct {
+	number         int
+	offset, length int64
+	sha1Sum        string
+}
+
+// uploadLarge uploads the first size bytes of r as a large file.
+func (b *Bucket) uploadLarge(r io.ReadSeeker, size int64, name, sha1Sum string, partSize int64, info map[string]string, opts *UploadOptions) (*FileInfo, error) {
+	var err error
+	var parts []*largeFilePart
+	if opts.TrailingSHA1 && !opts.Resume {
+		for offset := int64(0); offset < size; offset += partSize {
+			parts = append(parts, &largeFilePart{
+				number: len(parts) + 1,
+				offset: offset, length: min64(partSize, size-offset),
+				sha1Sum: HexDigitsAtEnd,
+			})
+		}
+		if sha1Sum != "" {
+			info["large_file_sha1"] = sha1Sum
+			if info, err = checkFileInfo(info); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		fileHash := sha1.New()
+		for offset := int64(0); offset < size; offset += partSize {
+			length := min64(partSize, size-offset)
+			partHash := sha1.New()
+			if _, err := io.CopyN(io.MultiWriter(fileHash, partHash), r, length); err != nil {
+				return nil, err
+			}
+			parts = append(parts, &largeFilePart{
+				number: len(parts) + 1,
+				offset: offset, length: length,
+				sha1Sum: hex.EncodeToString(partHash.Sum(nil)),
+			})
+		}
+		info["large_file_sha1"] = hex.EncodeToString(fileHash.Sum(nil))
+		if info, err = checkFileInfo(info); err != nil {
+			return nil, err
+		}
+	}
+	debugf("upload %s: large file of %d parts", name, len(parts))
+
+	var lf *LargeFile
+	missing := parts
+	if opts.Resume {
+		lf, err = b.findUnfinishedLargeFile(name, opts.ContentType, info)
+		if err != nil {
+			return nil, err
+		}
+		if lf != nil {
+			if missing, err = lf.missingParts(parts); err != nil {
+				return nil, err
+			}
+			debugf("upload %s: resuming %s, %d parts missing", name, lf.ID, len(missing))
+			if opts.ServerSideEncryption != nil {
+				lf.ServerSideEncryption = opts.ServerSideEncryption
+			}
+		}
+	}
+	if lf == nil {
+		lf, err = b.startLargeFile(name, opts.ContentType, info, opts.ServerSideEncryption)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t := newProgressTracker(opts.Progress, opts.ProgressInterval, size)
+	uploaded := size
+	for _, p := range missing {
+		uploaded -= p.length
+	}
+	t.add(uploaded) // parts already uploaded, if resuming
+
+	if err := lf.uploadParts(r, missing, opts, t); err != nil {
+		if !opts.Resume {
+			if err := b.c.CancelLargeFile(lf.ID); err != nil {
+				debugf("upload %s: cancel: %s", name, err)
+			}
+		}
+		return nil, err
+	}
+
+	sha1s := make([]string, len(parts))
+	for i, p := range parts {
+		sha1s[i] = p.sha1Sum
+	}
+	fi, err := b.c.FinishLargeFile(lf.ID, sha1s)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUploadedFile(fi, name, "", size); err != nil {
+		return nil, err
+	}
+	t.done()
+	return fi, nil
+}
+
+// findUnfinishedLargeFile returns the unfinished large file with the given
+// name, content type and metadata, or nil if there is none.
+func (b *Bucket) findUnfinishedLargeFile(name, mimeType string, info map[string]string) (*LargeFile, error) {
+	l := b.ListUnfinishedLargeFiles(name)
+	for l.Next() {
+		lf := l.LargeFile()
+		if lf.Name != name || len(lf.CustomMetadata) != len(info) {
+			continue
+		}
+		if mimeType != "" && mimeType != "b2/x-auto" && lf.ContentType != mimeType {
+			continue
+		}
+		match := true
+		for k, v := range info {
+			if lf.CustomMetadata[k] != v {
+				match = false
+			}
+		}
+		if match {
+			return lf, nil
+		}
+	}
+	return nil, l.Err()
+}
+
+// missingParts returns the parts that were not already uploaded to lf with
+// the same length and SHA1.
+func (lf *LargeFile) missingParts(parts []*largeFilePart) ([]*largeFilePart, error) {
+	uploaded := make(map[int]*PartInfo)
+	l := lf.ListParts()
+	for l.Next() {
+		uploaded[l.PartInfo().PartNumber] = l.PartInfo()
+	}
+	if err := l.Err(); err != nil {
+		return nil, err
+	}
+	var missing []*largeFilePart
+	for _, p := range parts {
+		pi := uploaded[p.number]
+		if pi == nil || pi.ContentLength != p.length || plainSHA1(pi.ContentSHA1) != p.sha1Sum {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// uploadParts uploads parts read from r concurrently, retrying each on failure.
+// If r is not an io.ReaderAt, each part is read into memory under a lock.
+func (lf *LargeFile) uploadParts(r io.ReadSeeker, parts []*largeFilePart, opts *UploadOptions, t *progressTracker) error {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	var (
+		wg       sync.WaitGroup
+		readMu   sync.Mutex
+		mu       sync.Mutex // protects firstErr
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	sem := make(chan struct{}, concurrency)
+
+	for _, p := range parts {
+		if failed() {
+			break
+		}
+		p := p
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			body, err := p.reader(r, &readMu)
+			if err == nil {
+				err = lf.uploadPartWithRetries(body, p, t, opts)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// reader returns an io.ReadSeeker over the part of r. If r is not an
+// io.ReaderAt, the part is read into memory while holding readMu.
+func (p *largeFilePart) reader(r io.ReadSeeker, readMu *sync.Mutex) (io.ReadSeeker, error) {
+	if ra, ok := r.(io.ReaderAt); ok {
+		return io.NewSectionReader(ra, p.offset, p.length), nil
+	}
+	buf := make([]byte, p.length)
+	readMu.Lock()
+	defer readMu.Unlock()
+	if _, err := r.Seek(p.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf), nil
+}
+
+// uploadPartWithRetries uploads p from body, reporting progress to t.
+// If p.sha1Sum is HexDigitsAtEnd, it's replaced with the actual SHA1 on success.
+func (lf *LargeFile) uploadPartWithRetries(body io.ReadSeeker, p *largeFilePart, t *progressTracker, opts *UploadOptions) error {
+	var err error
+	pr := t.reader(body)
+	for i := 0; i < maxAttempts; i++ {
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var pi *PartInfo
+		if pi, err = lf.uploadPart(pr, p.number, p.sha1Sum, p.length, opts); err == nil {
+			p.sha1Sum = plainSHA1(pi.ContentSHA1)
+			return nil
+		}
+		if _, ok := err.(*IntegrityError); ok {
+			// B2 stored something else, retrying would hide it.
+			return err
+		}
+		if i+1 < maxAttempts {
+			t.retry(pr)
+		}
+	}
+	return err
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// A LargeFileListing is the result of (*Bucket).ListUnfinishedLargeFiles.
+// It works like Listing: use Next to advance and then LargeFile, and check
+// Err once Next returns false.
+type LargeFileListing struct {
+	b             *Bucket
+	namePrefix    string
+	nextPageCount int
+	nextID        *string
+	objects       []*LargeFile // in reverse order
+	err           error
+}
+
+// ListUnfinishedLargeFiles returns a LargeFileListing of the large files in
+// the Bucket that were started but not finished or canceled, with names
+// starting with namePrefix. To list all of them, set namePrefix to "".
+func (b *Bucket) ListUnfinishedLargeFiles(namePrefix string) *LargeFileListing {
+	start := ""
+	return &LargeFileListing{
+		b:          b,
+		namePrefix: namePrefix,
+		nextID:     &start,
+	}
+}
+
+// SetPageCount controls the number of results to be fetched with each API
+// call. The maximum n is 100, higher values are automatically limited to 100.
+func (l *LargeFileListing) SetPageCount(n int) {
+	if n > 100 {
+		n = 100
+	}
+	l.nextPageCount = n
+}
+
+// Next calls the list API if needed and prepares the LargeFile results.
+// It returns true on success, or false if there is no next result
+// or an error happened while preparing it. Err should be
+// consulted to distinguish between the two cases.
+func (l *LargeFileListing) Next() bool {
+	if l.err != nil {
+		return false
+	}
+	if len(l.objects) > 0 {
+		l.objects = l.objects[:len(l.objects)-1]
+	}
+	if len(l.objects) > 0 {
+		return true
+	}
+	if l.nextID == nil {
+		return false // end of iteration
+	}
+
+	data := map[string]interface{}{
+		"bucketId": l.b.ID,
+	}
+	if l.namePrefix != "" {
+		data["namePrefix"] = l.namePrefix
+	}
+	if *l.nextID != "" {
+		data["startFileId"] = *l.nextID
+	}
+	if l.nextPageCount > 0 {
+		data["maxFileCount"] = l.nextPageCount
+	}
+	r, err := l.b.c.doRequest("b2_list_unfinished_large_files", data)
+	if err != nil {
+		l.err = err
+		return false
+	}
+	defer drainAndClose(r.Body)
+
+	var x struct {
+		Files      []fileInfoObj
+		NextFileID *string
+	}
+	if l.err = json.NewDecoder(r.Body).Decode(&x); l.err != nil {
+		return false
+	}
+
+	l.objects = make([]*LargeFile, len(x.Files))
+	for i, f := range x.Files {
+		l.objects[len(l.objects)-1-i] = f.makeLargeFile(l.b.c)
+	}
+	l.nextID = x.NextFileID
+	return len(l.objects) > 0
+}
+
+// LargeFile returns the LargeFile object made available by Next.
+//
+// LargeFile must only be called after a call to Next returned true.
+func (l *LargeFileListing) LargeFile() *LargeFile {
+	return l.objects[len(l.objects)-1]
+}
+
+// Err returns the error, if any, that was encountered while listing.
+func (l *LargeFileListing) Err() error {
+	return l.err
+}
+
+// A PartListing is the result of (*LargeFile).ListParts.
+// It works like Listing: use Next to advance and then PartInfo, and check
+// Err once Next returns false.
+type PartListing struct {
+	lf            *LargeFile
+	nextPageCount int
+	nextPart      *int
+	objects       []*PartInfo // in reverse order
+	err           error
+}
+
+// ListParts returns a PartListing of the parts uploaded so far to lf,
+// sorted by part number.
+func (lf *LargeFile) ListParts() *PartListing {
+	start := 1
+	return &PartListing{
+		lf:       lf,
+		nextPart: &start,
+	}
+}
+
+// SetPageCount controls the number of results to be fetched with each API
+// call. The maximum n is 1000, higher values are automatically limited to 1000.
+func (l *PartListing) SetPageCount(n int) {
+	if n > 1000 {
+		n = 1000
+	}
+	l.nextPageCount = n
+}
+
+// Next calls the list API if needed and prepares the PartInfo results.
+// It returns true on success, or false if there is no next result
+// or an error happened while preparing it. Err should be
+// consulted to distinguish between the two cases.
+func (l *PartListing) Next() bool {
+	if l.err != nil {
+		return false
+	}
+	if len(l.objects) > 0 {
+		l.objects = l.objects[:len(l.objects)-1]
+	}
+	if len(l.objects) > 0 {
+		return true
+	}
+	if l.nextPart == nil {
+		return false // end of iteration
+	}
+
+	data := map[string]interface{}{
+		"fileId":          l.lf.ID,
+		"startPartNumber": *l.nextPart,
+	}
+	if l.nextPageCount > 0 {
+		data["maxPartCount"] = l.nextPageCount
+	}
+	r, err := l.lf.c.doRequest("b2_list_parts", data)
+	if err != nil {
+		l.err = err
+		return false
+	}
+	defer drainAndClose(r.Body)
+
+	var x struct {
+		Parts          []partInfoObj
+		NextPartNumber *int
+	}
+	if l.err = json.NewDecoder(r.Body).Decode(&x); l.err != nil {
+		return false
+	}
+
+	l.objects = make([]*PartInfo, len(x.Parts))
+	for i, p := range x.Parts {
+		l.objects[len(l.objects)-1-i] = p.makePartInfo()
+	}
+	l.nextPart = x.NextPartNumber
+	return len(l.objects) > 0
+}
+
+// PartInfo returns the PartInfo object made available by Next.
+//
+// PartInfo must only be called after a call to Next returned true.
+func (l *PartListing) PartInfo() *PartInfo {
+	return l.objects[len(l.objects)-1]
+}
+
+// Err returns the error, if any, that was encountered while listing.
+func (l *PartListing) Err() error {
+	return l.err
+}
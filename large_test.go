@@ -0,0 +1,211 @@
+package b2_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/FiloSottile/b2"
+)
+
+func TestLargeFileLifecycle(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	li, err := c.LoginInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partSize := li.AbsoluteMinimumPartSize
+	if partSize == 0 {
+		t.Fatal("missing AbsoluteMinimumPartSize")
+	}
+
+	file := make([]byte, partSize+1234)
+	rand.Read(file)
+	lf, err := b.StartLargeFile("test-large", "", map[string]string{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := [][]byte{file[:partSize], file[partSize:]}
+	var sha1s []string
+	for i, p := range parts {
+		digest := sha1.Sum(p)
+		sha1s = append(sha1s, hex.EncodeToString(digest[:]))
+		pi, err := lf.UploadPart(bytes.NewReader(p), i+1, sha1s[i], int64(len(p)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pi.PartNumber != i+1 {
+			t.Errorf("wrong part number: %d", pi.PartNumber)
+		}
+		if pi.ContentSHA1 != sha1s[i] {
+			t.Errorf("mismatched part SHA1: %s", pi.ContentSHA1)
+		}
+	}
+
+	fi, err := c.FinishLargeFile(lf.ID, sha1s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	if fi.ID != lf.ID {
+		t.Error("mismatched file ID")
+	}
+	if fi.ContentLength != len(file) {
+		t.Error("mismatched file length", fi.ContentLength)
+	}
+	if fi.CustomMetadata["foo"] != "bar" {
+		t.Error("missing custom metadata", fi.CustomMetadata)
+	}
+
+	rc, _, err := c.DownloadFileByID(fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	body, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, file) {
+		t.Error("mismatch in file contents")
+	}
+}
+
+func TestLargeFileCancel(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	lf, err := b.StartLargeFile("test-large", "application/octet-stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := lf.GetUploadPartURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.FileID != lf.ID {
+		t.Error("mismatched upload part URL file ID")
+	}
+
+	l := b.ListFilesVersions("", "")
+	if !l.Next() || l.FileInfo().ID != lf.ID || l.FileInfo().Action != "start" {
+		t.Fatal("unfinished large file not listed", l.Err())
+	}
+
+	if err := c.CancelLargeFile(lf.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadLarge(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	li, err := c.LoginInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partSize := li.AbsoluteMinimumPartSize
+
+	file := make([]byte, 2*partSize+1234)
+	rand.Read(file)
+	for _, r := range []io.Reader{
+		bytes.NewReader(file),
+		struct{ io.ReadSeeker }{bytes.NewReader(file)}, // hide ReadAt
+	} {
+		fi, err := b.UploadWithOptions(r, "test-large", &b2.UploadOptions{
+			PartSize:           partSize,
+			LargeFileThreshold: partSize,
+			Concurrency:        2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.DeleteFile(fi.ID, fi.Name)
+		if fi.ContentLength != len(file) {
+			t.Error("mismatched file length", fi.ContentLength)
+		}
+		digest := sha1.Sum(file)
+		if fi.CustomMetadata["large_file_sha1"] != hex.EncodeToString(digest[:]) {
+			t.Error("wrong large_file_sha1", fi.CustomMetadata)
+		}
+
+		// The download is verified against large_file_sha1.
+		rc, _, err := c.DownloadFileByID(fi.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, file) {
+			t.Error("mismatch in file contents")
+		}
+	}
+}
+
+func TestUploadLargeResume(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	li, err := c.LoginInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partSize := li.AbsoluteMinimumPartSize
+
+	file := make([]byte, 2*partSize+1234)
+	rand.Read(file)
+	digest := sha1.Sum(file)
+
+	// Simulate an interrupted upload, with only the first part uploaded.
+	lf, err := b.StartLargeFile("test-resume", "", map[string]string{
+		"large_file_sha1": hex.EncodeToString(digest[:]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partDigest := sha1.Sum(file[:partSize])
+	if _, err := lf.UploadPart(bytes.NewReader(file[:partSize]), 1,
+		hex.EncodeToString(partDigest[:]), partSize); err != nil {
+		t.Fatal(err)
+	}
+
+	l := b.ListUnfinishedLargeFiles("test-")
+	if !l.Next() || l.LargeFile().ID != lf.ID {
+		t.Fatal("unfinished large file not listed", l.Err())
+	}
+	pl := l.LargeFile().ListParts()
+	if !pl.Next() || pl.PartInfo().PartNumber != 1 || pl.Next() {
+		t.Fatal("wrong parts listing", pl.Err())
+	}
+
+	fi, err := b.UploadWithOptions(bytes.NewReader(file), "test-resume", &b2.UploadOptions{
+		PartSize:           partSize,
+		LargeFileThreshold: partSize,
+		Resume:             true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	if fi.ID != lf.ID {
+		t.Error("the unfinished large file was not resumed")
+	}
+	if fi.ContentLength != len(file) {
+		t.Error("mismatched file length", fi.ContentLength)
+	}
+}
@@ -0,0 +1,300 @@
+package b2
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// OpenFileOptions are the optional parameters of (*Client).OpenFile.
+// The zero value and nil are valid and select the defaults.
+type OpenFileOptions struct {
+	// DownloadOptions are applied to every ranged request. Offset, Length
+	// and Progress are ignored.
+	DownloadOptions
+
+	// BlockSize is the size in bytes of the blocks the file is read in.
+	// If zero, 1MB is used.
+	BlockSize int64
+
+	// CacheBlocks is the maximum number of blocks kept in memory. If zero,
+	// 16 is used. It's raised to Readahead+1 if lower.
+	CacheBlocks int
+
+	// Readahead is the number of blocks following a missing one that are
+	// fetched with the same request, if they are not cached yet.
+	Readahead int
+}
+
+const (
+	defaultBlockSize   = 1 << 20
+	defaultCacheBlocks = 16
+)
+
+// A RemoteFile is an open B2 file, read with ranged downloads as needed.
+// It implements io.ReaderAt, io.ReadSeeker and io.Closer, so it can be
+// passed to packages like archive/zip without downloading the whole file.
+//
+// All reads are of the file version that was current when it was opened,
+// even if a new version is uploaded later. ReadAt is safe for concurrent
+// use, Read and Seek are not.
+type RemoteFile struct {
+	c         *Client
+	fi        *FileInfo
+	size      int64
+	opts      DownloadOptions
+	blockSize int64
+	maxBlocks int
+	readahead int
+
+	off int64 // for Read and Seek
+
+	mu       sync.Mutex
+	closed   bool
+	lru      *list.List // of *cachedBlock, most recent first
+	cache    map[int64]*list.Element
+	inflight map[int64]*blockFetch
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+// blockFetch is a ranged request in progress for one or more blocks.
+type blockFetch struct {
+	done   chan struct{}
+	blocks map[int64][]byte
+	err    error
+}
+
+// OpenFile opens the latest version of the file with the given name in the
+// named bucket. The first block is fetched immediately, to pin the file ID.
+// The RemoteFile must be closed to release its cache.
+func (c *Client) OpenFile(bucket, name string, opts *OpenFileOptions) (*RemoteFile, error) {
+	return c.openFile("/file/"+bucket+"/"+escapePath(name), name, opts)
+}
+
+// OpenFileByID is like OpenFile, but opens the file version with the given ID.
+func (c *Client) OpenFileByID(id string, opts *OpenFileOptions) (*RemoteFile, error) {
+	return c.openFile(apiPath+"b2_download_file_by_id?fileId="+id, id, opts)
+}
+
+func (c *Client) openFile(path, name string, opts *OpenFileOptions) (*RemoteFile, error) {
+	if opts == nil {
+		opts = &OpenFileOptions{}
+	}
+	f := &RemoteFile{
+		c:         c,
+		opts:      opts.DownloadOptions,
+		blockSize: opts.BlockSize,
+		maxBlocks: opts.CacheBlocks,
+		readahead: opts.Readahead,
+		lru:       list.New(),
+		cache:     make(map[int64]*list.Element),
+		inflight:  make(map[int64]*blockFetch),
+	}
+	f.opts.Progress = nil
+	if f.blockSize <= 0 {
+		f.blockSize = defaultBlockSize
+	}
+	if f.maxBlocks <= 0 {
+		f.maxBlocks = defaultCacheBlocks
+	}
+	if f.maxBlocks < f.readahead+1 {
+		f.maxBlocks = f.readahead + 1
+	}
+
+	body, fi, err := c.downloadHead(path, f.opts, f.blockSize)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	debugf("open %s (%s, %d bytes)", name, fi.ID, fi.ContentLength)
+	f.fi, f.size = fi, int64(fi.ContentLength)
+	if len(data) > 0 {
+		f.addBlock(0, data)
+	}
+	return f, nil
+}
+
+// FileInfo returns the FileInfo of the open file version, as returned by
+// DownloadFileByIDWithOptions.
+func (f *RemoteFile) FileInfo() *FileInfo {
+	return f.fi
+}
+
+// Size returns the length of the file in bytes.
+func (f *RemoteFile) Size() int64 {
+	return f.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *RemoteFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("b2: negative offset")
+	}
+	for len(p) > 0 {
+		if off >= f.size {
+			return n, io.EOF
+		}
+		data, err := f.block(off / f.blockSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p, data[off%f.blockSize:])
+		n += c
+		off += int64(c)
+		p = p[c:]
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (f *RemoteFile) Read(p []byte) (n int, err error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.size-f.off {
+		p = p[:f.size-f.off]
+	}
+	n, err = f.ReadAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (f *RemoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("b2: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("b2: negative position")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Close releases the cache. Reads after Close fail.
+func (f *RemoteFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return errors.New("b2: RemoteFile already closed")
+	}
+	f.closed = true
+	f.lru.Init()
+	f.cache = nil
+	return nil
+}
+
+func (f *RemoteFile) numBlocks() int64 {
+	return (f.size + f.blockSize - 1) / f.blockSize
+}
+
+// block returns the contents of block i, from the cache or from a new or
+// in-flight request.
+func (f *RemoteFile) block(i int64) ([]byte, error) {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil, errors.New("b2: read of closed RemoteFile")
+	}
+	if e, ok := f.cache[i]; ok {
+		f.lru.MoveToFront(e)
+		f.mu.Unlock()
+		return e.Value.(*cachedBlock).data, nil
+	}
+	bf, ok := f.inflight[i]
+	if !ok {
+		bf = &blockFetch{done: make(chan struct{})}
+		n := int64(1)
+		for n <= int64(f.readahead) && i+n < f.numBlocks() {
+			if _, ok := f.cache[i+n]; ok {
+				break
+			}
+			if _, ok := f.inflight[i+n]; ok {
+				break
+			}
+			n++
+		}
+		for j := i; j < i+n; j++ {
+			f.inflight[j] = bf
+		}
+		go f.fetch(bf, i, n)
+	}
+	f.mu.Unlock()
+
+	<-bf.done
+	if bf.err != nil {
+		return nil, bf.err
+	}
+	return bf.blocks[i], nil
+}
+
+// fetch downloads n blocks starting at first, and adds them to the cache.
+func (f *RemoteFile) fetch(bf *blockFetch, first, n int64) {
+	defer close(bf.done)
+
+	o := f.opts
+	o.Offset = first * f.blockSize
+	o.Length = min64(n*f.blockSize, f.size-o.Offset)
+	data, err := f.download(&o)
+	if err == nil && int64(len(data)) != o.Length {
+		err = io.ErrUnexpectedEOF
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for j := first; j < first+n; j++ {
+		delete(f.inflight, j)
+	}
+	if err != nil {
+		debugf("read %s blocks %d+%d: %s", f.fi.Name, first, n, err)
+		bf.err = err
+		return
+	}
+	bf.blocks = make(map[int64][]byte, n)
+	for j := int64(0); j < n; j++ {
+		b := data[j*f.blockSize : min64((j+1)*f.blockSize, int64(len(data)))]
+		bf.blocks[first+j] = b
+		if !f.closed {
+			f.addBlock(first+j, b)
+		}
+	}
+}
+
+func (f *RemoteFile) download(o *DownloadOptions) ([]byte, error) {
+	body, _, err := f.c.DownloadFileByIDWithOptions(f.fi.ID, o)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// addBlock adds a block to the cache, evicting the least recently used
+// ones if needed. It must be called with mu held.
+func (f *RemoteFile) addBlock(i int64, data []byte) {
+	if _, ok := f.cache[i]; ok {
+		return
+	}
+	f.cache[i] = f.lru.PushFront(&cachedBlock{index: i, data: data})
+	for f.lru.Len() > f.maxBlocks {
+		e := f.lru.Back()
+		f.lru.Remove(e)
+		delete(f.cache, e.Value.(*cachedBlock).index)
+	}
+}
@@ -0,0 +1,386 @@
+package b2
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ParallelDownloadOptions are the optional parameters of the parallel
+// download functions, like (*Client).ParallelDownloadFileByID and
+// DownloadFileByIDParallel.
+// The zero value and nil are valid and select the defaults.
+type ParallelDownloadOptions struct {
+	// DownloadOptions are applied to every ranged request. Offset and
+	// Length are ignored. Progress reports the progress of the whole file.
+	DownloadOptions
+
+	// PartSize is the size in bytes of the ranges fetched concurrently.
+	// If zero, 32MB is used. Up to Concurrency parts are kept in memory.
+	PartSize int64
+
+	// Concurrency is the number of ranges fetched in parallel. If zero,
+	// 4 is used.
+	Concurrency int
+}
+
+const defaultDownloadPartSize = 32 << 20
+
+// ParallelDownloadFileByID downloads the file with the given ID into w,
+// fetching ranges of it concurrently over multiple connections and retrying
+// each on failure. w is written at the offsets of the file, like an *os.File.
+//
+// Once the whole file is written, its SHA1, or the large_file_sha1 info for
+// large files, is verified, and an *IntegrityError is returned on mismatch,
+// unless opts.DisableSHA1Check is set.
+func (c *Client) ParallelDownloadFileByID(id string, w io.WriterAt, opts *ParallelDownloadOptions) (*FileInfo, error) {
+	return c.parallelDownload(apiPath+"b2_download_file_by_id?fileId="+id, w, opts)
+}
+
+// ParallelDownloadFileByName is like ParallelDownloadFileByID, but downloads
+// the latest version of the file with the given name. All ranges are fetched
+// from the same version, even if a new one is uploaded meanwhile.
+func (c *Client) ParallelDownloadFileByName(bucket, file string, w io.WriterAt, opts *ParallelDownloadOptions) (*FileInfo, error) {
+	return c.parallelDownload("/file/"+bucket+"/"+escapePath(file), w, opts)
+}
+
+func (c *Client) parallelDownload(path string, w io.WriterAt, opts *ParallelDownloadOptions) (*FileInfo, error) {
+	if opts == nil {
+		opts = &ParallelDownloadOptions{}
+	}
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = defaultDownloadPartSize
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	o := opts.DownloadOptions
+	o.Progress = nil
+
+	first, fi, t, err := c.downloadFirstPart(path, opts, partSize)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(fi.ContentLength)
+	h := &orderedHasher{h: sha1.New(), parts: make(map[int64][]byte)}
+	sem := make(chan struct{}, concurrency) // one token per part buffer
+
+	sem <- struct{}{}
+	if _, err := w.WriteAt(first, 0); err != nil {
+		return nil, err
+	}
+	h.add(0, first, sem)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex // protects firstErr
+		firstErr error
+		abort    = make(chan struct{}) // closed when firstErr is set
+	)
+parts:
+	for n, off := int64(1), partSize; off < size; n, off = n+1, off+partSize {
+		// Parts after a failed one are never hashed and keep their
+		// tokens, so waiting for one must stop on failure.
+		select {
+		case sem <- struct{}{}:
+		case <-abort:
+			break parts
+		}
+		n, off := n, off
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			po := o
+			po.Offset, po.Length = off, min64(partSize, size-off)
+			buf, err := c.downloadPartWithRetries(fi.ID, &po, t)
+			if err == nil {
+				_, err = w.WriteAt(buf, off)
+			}
+			if err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				if firstErr == nil {
+					firstErr = err
+					close(abort)
+				}
+				return
+			}
+			h.add(n, buf, sem)
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	if !opts.DisableSHA1Check {
+		if err := checkDownloadedSHA1(fi, hex.EncodeToString(h.h.Sum(nil))); err != nil {
+			return nil, err
+		}
+	}
+	t.done()
+	return fi, nil
+}
+
+// downloadFirstPart downloads the first part of path, which pins the file ID
+// and tells the size, and returns it along with the FileInfo and a progress
+// tracker for the whole file.
+func (c *Client) downloadFirstPart(path string, opts *ParallelDownloadOptions, partSize int64) ([]byte, *FileInfo, *progressTracker, error) {
+	o := opts.DownloadOptions
+	o.Progress = nil
+	body, fi, err := c.downloadHead(path, o, partSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	size := int64(fi.ContentLength)
+	t := newProgressTracker(opts.Progress, opts.ProgressInterval, size)
+	// The body resumes on its own, so a failure is not retried.
+	buf, err := readPart(t.reader(body), min64(partSize, size))
+	body.Close()
+	if err != nil {
+		debugf("download %s range 0+%d: %s", fi.ID, partSize, err)
+		return nil, nil, nil, err
+	}
+	return buf, fi, t, nil
+}
+
+// downloadPartWithRetries downloads the range of the file with the given ID
+// selected by o, reporting progress to t. Requests are retried, with a
+// growing delay, only if they failed with a temporary error. Failures while
+// reading the body are returned, as the body already resumes on its own.
+func (c *Client) downloadPartWithRetries(id string, o *DownloadOptions, t *progressTracker) ([]byte, error) {
+	var err error
+	for i := 0; i < maxAttempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * retryDelay)
+		}
+		var body io.ReadCloser
+		body, _, err = c.DownloadFileByIDWithOptions(id, o)
+		if err != nil {
+			debugf("download %s range %d+%d: %s", id, o.Offset, o.Length, err)
+			if !temporaryError(err) {
+				return nil, err
+			}
+			continue
+		}
+		buf, err := readPart(t.reader(body), o.Length)
+		body.Close()
+		return buf, err
+	}
+	return nil, err
+}
+
+// retryDelay is the delay before the first retry of a download request.
+const retryDelay = 250 * time.Millisecond
+
+// temporaryError reports whether a failed request might succeed if retried:
+// transport failures and B2 errors with status 408, 429 or 5xx. Client
+// errors, like a missing file or a wrong encryption key, are not.
+func temporaryError(err error) bool {
+	e, ok := UnwrapError(err)
+	if !ok {
+		_, ok := err.(*url.Error)
+		return ok
+	}
+	return e.Status >= 500 || e.Status == http.StatusRequestTimeout ||
+		e.Status == http.StatusTooManyRequests
+}
+
+// readPart reads exactly n bytes from r.
+func readPart(r io.Reader, n int64) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// orderedHasher hashes parts completed in any order, in order, keeping the
+// ones that arrive early until their turn. A token is received from sem
+// once a part is hashed and its buffer released.
+type orderedHasher struct {
+	mu    sync.Mutex
+	h     hash.Hash
+	next  int64
+	parts map[int64][]byte
+}
+
+func (o *orderedHasher) add(n int64, buf []byte, sem chan struct{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.parts[n] = buf
+	for {
+		buf, ok := o.parts[o.next]
+		if !ok {
+			return
+		}
+		o.h.Write(buf)
+		delete(o.parts, o.next)
+		o.next++
+		<-sem
+	}
+}
+
+// DownloadFileByIDParallel is like DownloadFileByIDWithOptions, but the
+// returned reader prefetches the following opts.Concurrency ranges of
+// opts.PartSize bytes concurrently, retrying each on failure, while
+// delivering the bytes in order. At most Concurrency parts are kept in memory.
+//
+// The file SHA1, or its large_file_sha1 info, is verified when the end is
+// reached, and an *IntegrityError is returned instead of io.EOF on mismatch,
+// unless opts.DisableSHA1Check is set.
+// The ReadCloser must be closed by the caller once done reading.
+func (c *Client) DownloadFileByIDParallel(id string, opts *ParallelDownloadOptions) (io.ReadCloser, *FileInfo, error) {
+	return c.parallelReader(apiPath+"b2_download_file_by_id?fileId="+id, opts)
+}
+
+// DownloadFileByNameParallel is like DownloadFileByIDParallel, but reads the
+// latest version of the file with the given name. All ranges are fetched
+// from the same version, even if a new one is uploaded meanwhile.
+func (c *Client) DownloadFileByNameParallel(bucket, file string, opts *ParallelDownloadOptions) (io.ReadCloser, *FileInfo, error) {
+	return c.parallelReader("/file/"+bucket+"/"+escapePath(file), opts)
+}
+
+func (c *Client) parallelReader(path string, opts *ParallelDownloadOptions) (io.ReadCloser, *FileInfo, error) {
+	if opts == nil {
+		opts = &ParallelDownloadOptions{}
+	}
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = defaultDownloadPartSize
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	first, fi, t, err := c.downloadFirstPart(path, opts, partSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &parallelReader{
+		fi:    fi,
+		t:     t,
+		h:     sha1.New(),
+		sem:   make(chan struct{}, concurrency),
+		parts: make(chan *readaheadPart, concurrency),
+		quit:  make(chan struct{}),
+	}
+	r.sem <- struct{}{}
+	r.cur = first
+	if opts.DisableSHA1Check {
+		r.h = nil
+	}
+
+	o := opts.DownloadOptions
+	o.Progress = nil
+	go r.prefetch(c, &o, partSize)
+	return r, fi, nil
+}
+
+// parallelReader delivers in order the parts fetched by prefetch.
+type parallelReader struct {
+	fi *FileInfo
+	t  *progressTracker
+	h  hash.Hash // nil if opts.DisableSHA1Check
+
+	sem   chan struct{} // one token per part buffer
+	parts chan *readaheadPart
+	quit  chan struct{} // closed by Close
+
+	cur    []byte // unread bytes of the current part
+	err    error
+	closed bool
+}
+
+type readaheadPart struct {
+	done chan struct{}
+	buf  []byte
+	err  error
+}
+
+// prefetch starts the downloads of the parts after the first one, in order,
+// as buffers are released by Read.
+func (r *parallelReader) prefetch(c *Client, o *DownloadOptions, partSize int64) {
+	defer close(r.parts)
+	size := int64(r.fi.ContentLength)
+	for off := partSize; off < size; off += partSize {
+		select {
+		case r.sem <- struct{}{}:
+		case <-r.quit:
+			return
+		}
+		p := &readaheadPart{done: make(chan struct{})}
+		po := *o
+		po.Offset, po.Length = off, min64(partSize, size-off)
+		go func() {
+			defer close(p.done)
+			p.buf, p.err = c.downloadPartWithRetries(r.fi.ID, &po, r.t)
+		}()
+		select {
+		case r.parts <- p:
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+func (r *parallelReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, errors.New("b2: read of closed reader")
+	}
+	for len(r.cur) == 0 && r.err == nil {
+		r.err = r.next()
+	}
+	if len(r.cur) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.cur)
+	if r.h != nil {
+		r.h.Write(r.cur[:n])
+	}
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+// next releases the current part buffer and waits for the following part.
+// At the end of the file it verifies the SHA1 and returns io.EOF.
+func (r *parallelReader) next() error {
+	r.cur = nil
+	<-r.sem
+	p, ok := <-r.parts
+	if !ok {
+		if r.h != nil {
+			if err := checkDownloadedSHA1(r.fi, hex.EncodeToString(r.h.Sum(nil))); err != nil {
+				return err
+			}
+		}
+		r.t.done()
+		return io.EOF
+	}
+	<-p.done
+	if p.err != nil {
+		return p.err
+	}
+	r.cur = p.buf
+	return nil
+}
+
+// Close stops prefetching. Downloads already in flight are completed in the
+// background and discarded.
+func (r *parallelReader) Close() error {
+	if r.closed {
+		return errors.New("b2: reader already closed")
+	}
+	r.closed = true
+	close(r.quit)
+	return nil
+}
@@ -0,0 +1,258 @@
+package b2
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Default limits of an upload URL pool. Upload authorization tokens are valid
+// for 24 hours, so URLs are discarded well before that.
+const (
+	defaultPoolMaxIdle = 32
+	defaultPoolMaxAge  = 12 * time.Hour
+
+	// badHostTTL is for how long URLs of a host that failed are not reused.
+	badHostTTL = time.Minute
+)
+
+// UploadURLPoolStats are the statistics of an upload URL pool, returned by
+// (*Bucket).UploadURLPoolStats.
+type UploadURLPoolStats struct {
+	// Idle is the number of URLs currently in the pool.
+	Idle int
+
+	// Hits is the number of uploads that reused a pooled URL, and Misses
+	// the number that had to obtain a new one.
+	Hits, Misses int64
+
+	// Expired is the number of URLs discarded because they were too old
+	// or their token was rejected, Evicted the number discarded because
+	// their host failed, and Dropped the number discarded because the
+	// pool was full.
+	Expired, Evicted, Dropped int64
+}
+
+type pooledURL struct {
+	url, token string
+	obtained   time.Time
+}
+
+// uploadURLPool is a pool of upload URLs, each usable by one upload at a time.
+// The zero value is ready to use with the default limits.
+type uploadURLPool struct {
+	mu       sync.Mutex
+	maxIdle  int
+	maxAge   time.Duration
+	idle     []*pooledURL
+	badHosts map[string]time.Time
+	stats    UploadURLPoolStats
+}
+
+func (p *uploadURLPool) setLimits(maxIdle int, maxAge time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxIdle, p.maxAge = maxIdle, maxAge
+	p.idle = p.filter(time.Now())
+	for len(p.idle) > p.limitIdle() {
+		p.idle = p.idle[1:]
+		p.stats.Dropped++
+	}
+}
+
+func (p *uploadURLPool) limitIdle() int {
+	if p.maxIdle <= 0 {
+		return defaultPoolMaxIdle
+	}
+	return p.maxIdle
+}
+
+func (p *uploadURLPool) limitAge() time.Duration {
+	if p.maxAge <= 0 {
+		return defaultPoolMaxAge
+	}
+	return p.maxAge
+}
+
+// usable reports whether u can still be used, counting it in the stats
+// if not. It must be called with mu held.
+func (p *uploadURLPool) usable(u *pooledURL, now time.Time) bool {
+	if now.Sub(u.obtained) > p.limitAge() {
+		p.stats.Expired++
+		return false
+	}
+	if t, ok := p.badHosts[hostOf(u.url)]; ok {
+		if now.Sub(t) < badHostTTL {
+			p.stats.Evicted++
+			return false
+		}
+		delete(p.badHosts, hostOf(u.url))
+	}
+	return true
+}
+
+// filter returns the idle URLs that are still usable. It must be called
+// with mu held.
+func (p *uploadURLPool) filter(now time.Time) []*pooledURL {
+	idle := p.idle[:0]
+	for _, u := range p.idle {
+		if p.usable(u, now) {
+			idle = append(idle, u)
+		}
+	}
+	return idle
+}
+
+// get returns a pooled URL, or nil if a new one must be obtained.
+func (p *uploadURLPool) get() *pooledURL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for len(p.idle) > 0 {
+		u := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.usable(u, now) {
+			p.stats.Hits++
+			return u
+		}
+	}
+	p.stats.Misses++
+	return nil
+}
+
+// put returns u to the pool after a successful use.
+func (p *uploadURLPool) put(u *pooledURL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.usable(u, time.Now()) {
+		return
+	}
+	if len(p.idle) >= p.limitIdle() {
+		p.stats.Dropped++
+		return
+	}
+	p.idle = append(p.idle, u)
+}
+
+// release returns u to the pool after an upload that returned err, unless
+// err means that u should not be used anymore. readErr is the error returned
+// by the upload body, if any: a request that failed because of its reader
+// says nothing about the URL.
+//
+// A rejected token (401) discards only u, while transport failures and
+// server errors (5xx) evict all the pooled URLs of the same host. Other
+// errors, like an invalid file name, don't affect the URL.
+func (p *uploadURLPool) release(u *pooledURL, err, readErr error) {
+	if err == nil || readErr != nil {
+		p.put(u)
+		return
+	}
+	e, ok := UnwrapError(err)
+	switch {
+	case ok && e.Status == http.StatusUnauthorized:
+		p.mu.Lock()
+		p.stats.Expired++
+		p.mu.Unlock()
+	case !ok || e.Status >= 500:
+		p.evictHost(u)
+	default:
+		p.put(u)
+	}
+}
+
+// evictHost discards u and marks its host as bad for badHostTTL.
+func (p *uploadURLPool) evictHost(u *pooledURL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.badHosts == nil {
+		p.badHosts = make(map[string]time.Time)
+	}
+	now := time.Now()
+	p.badHosts[hostOf(u.url)] = now
+	p.stats.Evicted++
+	p.idle = p.filter(now)
+}
+
+func (p *uploadURLPool) getStats() UploadURLPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.Idle = len(p.idle)
+	return s
+}
+
+// readErrRecorder records the first error other than io.EOF returned by r.
+// The HTTP transport reads the body in its own goroutine.
+type readErrRecorder struct {
+	r   io.Reader
+	mu  sync.Mutex
+	err error
+}
+
+func (r *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.mu.Lock()
+		if r.err == nil {
+			r.err = err
+		}
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+func (r *readErrRecorder) error() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func hostOf(u string) string {
+	if pu, err := url.Parse(u); err == nil {
+		return pu.Host
+	}
+	return u
+}
+
+// SetUploadURLPoolLimits sets the maximum number of idle upload URLs kept by
+// b for reuse, and the maximum age after which they are discarded. Zero or
+// negative values select the defaults, 32 URLs and 12 hours.
+func (b *Bucket) SetUploadURLPoolLimits(maxIdle int, maxAge time.Duration) {
+	b.uploadURLs.setLimits(maxIdle, maxAge)
+}
+
+// UploadURLPoolStats returns the statistics of the upload URL pool of b.
+func (b *Bucket) UploadURLPoolStats() UploadURLPoolStats {
+	return b.uploadURLs.getStats()
+}
+
+// PrewarmUploadURLs obtains n new upload URLs in parallel and adds them to
+// the pool of b, up to its maximum idle size, so that a known burst of
+// concurrent uploads doesn't have to wait for b2_get_upload_url calls.
+func (b *Bucket) PrewarmUploadURLs(n int) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex // protects firstErr
+		firstErr error
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := b.newUploadURL()
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			b.uploadURLs.put(u)
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,127 @@
+package b2
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of the state of a transfer, passed to the Progress
+// callbacks of UploadOptions and DownloadOptions.
+type Progress struct {
+	// Bytes is the number of bytes transferred so far. It goes back
+	// when a failed attempt is retried.
+	Bytes int64
+
+	// Total is the expected number of bytes, or -1 if unknown.
+	Total int64
+
+	// Attempt is the current attempt, starting at 1. For large files it's
+	// incremented every time any part is retried.
+	Attempt int
+
+	// Retry is true if this update reports a retry, which reset Bytes
+	// by the amount transferred by the failed attempt.
+	Retry bool
+}
+
+// defaultProgressInterval is the default minimum interval between calls to
+// a Progress callback.
+const defaultProgressInterval = 500 * time.Millisecond
+
+// progressTracker aggregates the progress of a transfer, possibly made of
+// concurrent parts, and reports it to a callback at most once per interval.
+// A nil *progressTracker is valid and does nothing.
+type progressTracker struct {
+	fn       func(Progress)
+	interval time.Duration
+
+	mu       sync.Mutex // protects the fields below and serializes fn calls
+	p        Progress
+	last     time.Time
+	reported bool // the current state was already reported
+}
+
+func newProgressTracker(fn func(Progress), interval time.Duration, total int64) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+	if interval == 0 {
+		interval = defaultProgressInterval
+	}
+	return &progressTracker{
+		fn:       fn,
+		interval: interval,
+		p:        Progress{Total: total, Attempt: 1},
+	}
+}
+
+func (t *progressTracker) add(n int64) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Bytes += n
+	t.reported = false
+	if time.Since(t.last) >= t.interval || t.p.Bytes == t.p.Total {
+		t.report()
+	}
+}
+
+// retry reports that the attempt that read through pr failed and is being
+// retried, and resets pr.
+func (t *progressTracker) retry(pr *progressReader) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Bytes -= pr.n
+	pr.n = 0
+	t.p.Attempt++
+	t.p.Retry = true
+	t.report()
+	t.p.Retry = false
+}
+
+// done reports the final state, if it was not reported already.
+func (t *progressTracker) done() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.reported {
+		t.report()
+	}
+}
+
+func (t *progressTracker) report() {
+	t.last = time.Now()
+	t.reported = true
+	t.fn(t.p)
+}
+
+// reader returns a reader that counts the bytes read from r towards t.
+func (t *progressTracker) reader(r io.Reader) *progressReader {
+	return &progressReader{r: r, t: t}
+}
+
+type progressReader struct {
+	r io.Reader
+	t *progressTracker
+	n int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.n += int64(n)
+	pr.t.add(int64(n))
+	return n, err
+}
+
+type progressReadCloser struct {
+	*progressReader
+	io.Closer
+}
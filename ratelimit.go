@@ -0,0 +1,129 @@
+package b2
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A RateLimiter caps the bandwidth of the transfers it's applied to, in bytes
+// per second. The cap is shared fairly across concurrent transfers, and can
+// be changed at any time with SetRate, for example on a schedule.
+//
+// A RateLimiter is applied to a Client with SetUploadRateLimiter and
+// SetDownloadRateLimiter, or to a single operation with UploadOptions and
+// DownloadOptions. The same RateLimiter can be used for multiple operations.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64 // can go negative, as debt paid by sleeping
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter with the given rate in bytes per
+// second. A rate of zero or less means unlimited.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSecond, last: time.Now()}
+}
+
+// SetRate changes the rate of l. A rate of zero or less means unlimited.
+// It affects transfers already in progress.
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = bytesPerSecond
+	if l.rate <= 0 {
+		l.tokens = 0
+	}
+}
+
+// Rate returns the current rate of l in bytes per second.
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// refill adds the tokens accumulated since the last call, allowing bursts
+// of at most a tenth of a second.
+func (l *RateLimiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if burst := float64(l.rate) / 10; l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+}
+
+// wait blocks until n bytes can be transferred. Each caller reserves its bytes
+// and sleeps off its own debt, so concurrent transfers are served in order.
+func (l *RateLimiter) wait(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+// rateLimitChunk is the largest read allowed at once through a RateLimiter,
+// to keep concurrent transfers interleaved.
+const rateLimitChunk = 16 * 1024
+
+// reader returns a reader that reads from r at the rate of l.
+// If l is nil, r is returned.
+func (l *RateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, l: l}
+}
+
+type rateLimitedReader struct {
+	r io.Reader
+	l *RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateLimitChunk {
+		p = p[:rateLimitChunk]
+	}
+	n, err := r.r.Read(p)
+	r.l.wait(n)
+	return n, err
+}
+
+type rateLimitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// SetUploadRateLimiter sets a RateLimiter applied to all the uploads made by
+// c, in addition to any set in UploadOptions. l can be nil to remove it.
+func (c *Client) SetUploadRateLimiter(l *RateLimiter) {
+	c.uploadLimiter.Store(l)
+}
+
+// SetDownloadRateLimiter sets a RateLimiter applied to all the downloads made
+// by c, in addition to any set in DownloadOptions. l can be nil to remove it.
+func (c *Client) SetDownloadRateLimiter(l *RateLimiter) {
+	c.downloadLimiter.Store(l)
+}
+
+// limitReader applies the Client limiter stored in v and then l to r.
+func limitReader(r io.Reader, v *atomic.Value, l *RateLimiter) io.Reader {
+	if cl, ok := v.Load().(*RateLimiter); ok {
+		r = cl.reader(r)
+	}
+	return l.reader(r)
+}
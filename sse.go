@@ -0,0 +1,168 @@
+package b2
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Server-side encryption modes.
+const (
+	// SSEB2 encrypts files with keys managed by B2.
+	SSEB2 = "SSE-B2"
+	// SSEC encrypts files with a key provided by the customer, which is not
+	// stored by B2 and must be provided again to download the file.
+	SSEC = "SSE-C"
+)
+
+// ServerSideEncryption describes the server-side encryption of a file.
+type ServerSideEncryption struct {
+	// Mode is SSEB2 or SSEC.
+	Mode string
+
+	// Algorithm is the encryption algorithm. If "", "AES256" is used,
+	// which is the only one supported by B2.
+	Algorithm string
+
+	// CustomerKey is the 32 bytes key for SSEC. It is never returned
+	// in a FileInfo.
+	CustomerKey []byte
+
+	// CustomerKeyMD5 is the base64 encoded MD5 of CustomerKey. It's computed
+	// automatically from CustomerKey, and returned in FileInfo for SSEC
+	// files when available.
+	CustomerKeyMD5 string
+}
+
+func (sse *ServerSideEncryption) check() error {
+	switch sse.Mode {
+	case SSEB2:
+	case SSEC:
+		if len(sse.CustomerKey) != 32 {
+			return fmt.Errorf("SSE-C customer key must be 32 bytes, got %d", len(sse.CustomerKey))
+		}
+	default:
+		return fmt.Errorf("unknown server-side encryption mode %q", sse.Mode)
+	}
+	return nil
+}
+
+func (sse *ServerSideEncryption) algorithm() string {
+	if sse.Algorithm == "" {
+		return "AES256"
+	}
+	return sse.Algorithm
+}
+
+func (sse *ServerSideEncryption) customerKeyMD5() string {
+	sum := md5.Sum(sse.CustomerKey)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// setCustomerKeyHeaders sets the headers that provide the SSE-C key, needed
+// to upload and to download SSE-C files. sse can be nil.
+func (sse *ServerSideEncryption) setCustomerKeyHeaders(h http.Header) {
+	if sse == nil || sse.Mode != SSEC {
+		return
+	}
+	h.Set("X-Bz-Server-Side-Encryption-Customer-Algorithm", sse.algorithm())
+	h.Set("X-Bz-Server-Side-Encryption-Customer-Key", base64.StdEncoding.EncodeToString(sse.CustomerKey))
+	h.Set("X-Bz-Server-Side-Encryption-Customer-Key-Md5", sse.customerKeyMD5())
+}
+
+// setUploadHeaders sets the headers that request encryption on upload.
+// sse can be nil.
+func (sse *ServerSideEncryption) setUploadHeaders(h http.Header) {
+	if sse != nil && sse.Mode == SSEB2 {
+		h.Set("X-Bz-Server-Side-Encryption", sse.algorithm())
+	}
+	sse.setCustomerKeyHeaders(h)
+}
+
+// params returns the serverSideEncryption JSON parameter.
+func (sse *ServerSideEncryption) params() map[string]string {
+	p := map[string]string{
+		"mode":      sse.Mode,
+		"algorithm": sse.algorithm(),
+	}
+	if sse.Mode == SSEC {
+		p["customerKey"] = base64.StdEncoding.EncodeToString(sse.CustomerKey)
+		p["customerKeyMd5"] = sse.customerKeyMD5()
+	}
+	return p
+}
+
+type sseObj struct {
+	Mode           string `json:"mode"`
+	Algorithm      string `json:"algorithm"`
+	CustomerKeyMD5 string `json:"customerKeyMd5"`
+}
+
+func (o *sseObj) makeServerSideEncryption() *ServerSideEncryption {
+	if o == nil || o.Mode == "" {
+		return nil
+	}
+	return &ServerSideEncryption{
+		Mode:           o.Mode,
+		Algorithm:      o.Algorithm,
+		CustomerKeyMD5: o.CustomerKeyMD5,
+	}
+}
+
+func parseSSEHeaders(h http.Header) *ServerSideEncryption {
+	if alg := h.Get("X-Bz-Server-Side-Encryption-Customer-Algorithm"); alg != "" {
+		return &ServerSideEncryption{
+			Mode:           SSEC,
+			Algorithm:      alg,
+			CustomerKeyMD5: h.Get("X-Bz-Server-Side-Encryption-Customer-Key-Md5"),
+		}
+	}
+	if alg := h.Get("X-Bz-Server-Side-Encryption"); alg != "" {
+		return &ServerSideEncryption{Mode: SSEB2, Algorithm: alg}
+	}
+	return nil
+}
+
+// EncryptionKeyError is returned by downloads when a file encrypted with SSE-C
+// is accessed without a customer key, or with the wrong one. Use UnwrapError
+// to access the underlying Error.
+//
+// B2 reports key problems with the generic "bad_request" and "access_denied"
+// codes, so a "bad_request" is only reported this way if its message is about
+// the key or encryption, and an "access_denied" only if a key was sent.
+type EncryptionKeyError struct {
+	Err *Error
+}
+
+func (e *EncryptionKeyError) Error() string {
+	return "b2: missing or wrong SSE-C customer key: " + e.Err.Message
+}
+
+// checkEncryptionKeyError turns the B2 errors caused by a missing or wrong
+// SSE-C key into an EncryptionKeyError. sse is the encryption of the request,
+// and can be nil. Without a key B2 answers 400 "bad_request", and with a
+// wrong one also 403 "access_denied". Other bad requests, like an invalid
+// file ID or range, are left as they are.
+func checkEncryptionKeyError(err error, sse *ServerSideEncryption) error {
+	e, ok := UnwrapError(err)
+	if !ok {
+		return err
+	}
+	keySent := sse != nil && sse.Mode == SSEC
+	switch {
+	case e.Status == http.StatusBadRequest && e.Code == "bad_request" && aboutKey(e.Message):
+	case e.Status == http.StatusForbidden && e.Code == "access_denied" && keySent:
+	default:
+		return err
+	}
+	return &EncryptionKeyError{Err: e}
+}
+
+// aboutKey reports whether a B2 error message is about encryption keys.
+func aboutKey(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "key") || strings.Contains(msg, "encrypt") ||
+		strings.Contains(msg, "sse")
+}
@@ -5,59 +5,203 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"hash"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Upload uploads a file to a B2 bucket. If mimeType is "", "b2/x-auto" will be used.
+// It's equivalent to UploadWithOptions with only ContentType set.
 //
 // Concurrent calls to Upload will use separate upload URLs, but consequent ones
 // will attempt to reuse previously obtained ones to save b2_get_upload_url calls.
-// Upload URL failures are handled transparently.
+// Upload URL failures are handled transparently: the URLs of a host that failed
+// are evicted from the pool. See (*Bucket).SetUploadURLPoolLimits.
 //
 // Since the B2 API requires a SHA1 header, normally the file will first be read
 // entirely into a memory buffer. Two cases avoid the memory copy: if r is a
 // bytes.Buffer, the SHA1 will be computed in place; otherwise, if r implements io.Seeker
 // (like *os.File and *bytes.Reader), the file will be read twice, once to compute
-// the SHA1 and once to upload.
+// the SHA1 and once to upload. To buffer large inputs on disk instead, see
+// UploadOptions.SpillThreshold.
 //
 // If a file by this name already exist, a new version will be created.
 func (b *Bucket) Upload(r io.Reader, name, mimeType string) (*FileInfo, error) {
-	var body io.ReadSeeker
-	switch r := r.(type) {
-	case *bytes.Buffer:
-		defer r.Reset() // we are expected to consume it
-		body = bytes.NewReader(r.Bytes())
-	case io.ReadSeeker:
-		body = r
-	default:
-		debugf("upload %s: buffering", name)
-		b, err := ioutil.ReadAll(r)
-		if err != nil {
-			return nil, err
-		}
-		body = bytes.NewReader(b)
+	return b.UploadWithOptions(r, name, &UploadOptions{ContentType: mimeType})
+}
+
+// UploadOptions are the optional parameters of (*Bucket).UploadWithOptions.
+// The zero value and nil are valid and select the defaults.
+type UploadOptions struct {
+	// ContentType is the MIME type of the file. If "", "b2/x-auto" will be used.
+	ContentType string
+
+	// Info is the custom file information, returned as the CustomMetadata of
+	// the FileInfo. Keys are case-insensitive and are stored lowercased. B2
+	// allows at most 10 keys, made of letters, numbers, '-' and '_' and up
+	// to 50 characters long, and 7000 bytes of headers in total. These
+	// limits are checked before uploading.
+	//
+	// Large file uploads add the "large_file_sha1" key.
+	Info map[string]string
+
+	// ContentDisposition, ContentLanguage, Expires, CacheControl and
+	// ContentEncoding, if set, are returned as the corresponding HTTP
+	// headers when the file is downloaded. They are stored as the
+	// "b2-content-disposition" family of file info keys, and count
+	// towards the Info limits.
+	ContentDisposition string
+	ContentLanguage    string
+	Expires            string // in RFC 1123 format, see http.TimeFormat
+	CacheControl       string
+	ContentEncoding    string
+
+	// LargeFileThreshold is the size in bytes above which the file is
+	// uploaded in parts with the large file API. If zero, twice the part
+	// size is used. Files larger than 5GB are always uploaded as large files.
+	LargeFileThreshold int64
+
+	// PartSize is the size in bytes of the parts of a large file. If zero,
+	// LoginInfo.RecommendedPartSize is used, or 100MB if that's not set.
+	PartSize int64
+
+	// Concurrency is the number of parts of a large file uploaded in
+	// parallel. If zero, 4 is used.
+	Concurrency int
+
+	// ServerSideEncryption, if not nil, makes B2 encrypt the file at rest.
+	// Files encrypted with SSEC can only be downloaded with the same key.
+	ServerSideEncryption *ServerSideEncryption
+
+	// TrailingSHA1, if true, makes uploads of inputs of known length compute
+	// the SHA1 while the data is sent, and send it after the body, instead
+	// of reading the input twice. Large files uploaded this way don't have
+	// the "large_file_sha1" info, unless the SHA1 was already computed for
+	// a spilled input or by UploadIfChanged. It's ignored if Resume is set.
+	TrailingSHA1 bool
+
+	// Progress, if not nil, is called periodically with the progress of the
+	// upload, at most once every ProgressInterval and once at the end.
+	// If ProgressInterval is zero, 500ms is used. Calls are serialized.
+	Progress         func(Progress)
+	ProgressInterval time.Duration
+
+	// RateLimiter, if not nil, caps the bandwidth used by the upload, in
+	// addition to the Client-wide one set with SetUploadRateLimiter.
+	RateLimiter *RateLimiter
+
+	// Resume, if true, makes a large file upload look for an unfinished
+	// large file with the same name, content type and metadata (including
+	// large_file_sha1, so the same content) left by a previous attempt. If
+	// one is found, only the parts that are missing or don't match are
+	// uploaded before finishing it. On failure the unfinished large file is
+	// left in place, instead of being canceled, so that it can be resumed.
+	Resume bool
+
+	// SpillThreshold, if positive, limits the memory used to buffer inputs
+	// that are not a bytes.Buffer, an io.ReadSeeker or an io.ReaderAt: once
+	// more than SpillThreshold bytes are read, the input is written to a
+	// temporary file in SpillDir instead, computing its SHA1 on the way.
+	// If SpillDir is "", the default directory for temporary files is used.
+	// The temporary file is removed when the upload returns.
+	SpillThreshold int64
+	SpillDir       string
+}
+
+// maxAttempts is the number of times an upload is tried before giving up.
+const maxAttempts = 5
+
+// maxSimpleUploadSize is the largest file that can be uploaded without using
+// the large file API.
+const maxSimpleUploadSize = 5e9
+
+// UploadWithOptions is like Upload, but accepts additional options.
+//
+// If r is an io.ReadSeeker or an io.ReaderAt with a Size method (like
+// *bytes.Reader and *io.SectionReader), and it's larger than
+// opts.LargeFileThreshold, it is uploaded as a large file. The whole file is
+// read once to compute the large_file_sha1 and the SHA1 of each part, and
+// then the parts are uploaded concurrently, retrying each on failure. If r is
+// not an io.ReaderAt, each part is buffered in memory while being uploaded.
+//
+// If opts.TrailingSHA1 is set, bytes.Buffer, io.ReadSeeker and io.ReaderAt
+// inputs are read only once, computing the SHA1 while uploading.
+func (b *Bucket) UploadWithOptions(r io.Reader, name string, opts *UploadOptions) (*FileInfo, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	// Invalid options would fail every attempt, so they are checked once.
+	info, err := opts.check()
+	if err != nil {
+		return nil, err
 	}
 
-	h := sha1.New()
-	length, err := io.Copy(h, body)
+	body, spilledSHA1, cleanup, err := uploadReadSeeker(r, name, opts)
 	if err != nil {
 		return nil, err
 	}
-	sha1Sum := hex.EncodeToString(h.Sum(nil))
+	defer cleanup()
+	if buf, ok := r.(*bytes.Buffer); ok {
+		defer buf.Reset() // we are expected to consume it
+	}
+
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return b.upload(body, size, name, spilledSHA1, info, opts)
+}
+
+// upload uploads the size bytes of body, from its start. sha1Sum is the SHA1
+// of the contents if it's already known, or "". info must be the result of
+// opts.check.
+func (b *Bucket) upload(body io.ReadSeeker, size int64, name, sha1Sum string, info map[string]string, opts *UploadOptions) (*FileInfo, error) {
+	if partSize := b.c.partSize(opts, size); size > partSize &&
+		(size > opts.largeFileThreshold(partSize) || size > maxSimpleUploadSize) {
+		return b.uploadLarge(body, size, name, sha1Sum, partSize, info, opts)
+	}
+
+	length := size
+	if sha1Sum == "" && opts.TrailingSHA1 {
+		sha1Sum = HexDigitsAtEnd
+	} else if sha1Sum == "" {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		h := sha1.New()
+		n, err := io.Copy(h, body)
+		if err != nil {
+			return nil, err
+		}
+		length, sha1Sum = n, hex.EncodeToString(h.Sum(nil))
+	}
 
+	t := newProgressTracker(opts.Progress, opts.ProgressInterval, length)
+	pr := t.reader(body)
 	var fi *FileInfo
-	for i := 0; i < 5; i++ {
+	var err error
+	for i := 0; i < maxAttempts; i++ {
 		if _, err = body.Seek(0, io.SeekStart); err != nil {
 			return nil, err
 		}
 
-		fi, err = b.UploadWithSHA1(body, name, mimeType, sha1Sum, length)
+		fi, err = b.uploadWithSHA1(pr, name, sha1Sum, length, info, opts)
 		if err == nil {
+			t.done()
 			break
 		}
+		if _, ok := err.(*IntegrityError); ok {
+			// B2 stored something else, retrying would hide it.
+			return nil, err
+		}
 		if err, ok := UnwrapError(err); ok && err.Status == http.StatusUnauthorized {
 			// We are forced to pass nil to login, risking a double login (which is
 			// wasteful, but not harmful) because the API does not give us access to
@@ -67,82 +211,390 @@ func (b *Bucket) Upload(r io.Reader, name, mimeType string) (*FileInfo, error) {
 			}
 			i--
 		}
+		if i+1 < maxAttempts {
+			t.retry(pr)
+		}
 	}
 	return fi, err
 }
 
+// uploadReadSeeker returns an io.ReadSeeker over the contents of r, buffering
+// them in memory, or in a temporary file above opts.SpillThreshold, only if r
+// can't seek and is not an io.ReaderAt with a Size. If the contents were
+// spilled, sha1Sum is their SHA1, and cleanup removes the temporary file.
+// cleanup is never nil.
+func uploadReadSeeker(r io.Reader, name string, opts *UploadOptions) (body io.ReadSeeker, sha1Sum string, cleanup func(), err error) {
+	cleanup = func() {}
+	switch r := r.(type) {
+	case *bytes.Buffer:
+		return bytes.NewReader(r.Bytes()), "", cleanup, nil
+	case io.ReadSeeker:
+		return r, "", cleanup, nil
+	case sizeReaderAt:
+		return io.NewSectionReader(r, 0, r.Size()), "", cleanup, nil
+	}
+	if opts.SpillThreshold <= 0 {
+		debugf("upload %s: buffering", name)
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, "", cleanup, err
+		}
+		return bytes.NewReader(b), "", cleanup, nil
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, opts.SpillThreshold+1))
+	if err != nil {
+		return nil, "", cleanup, err
+	}
+	if int64(len(b)) <= opts.SpillThreshold {
+		debugf("upload %s: buffering", name)
+		return bytes.NewReader(b), "", cleanup, nil
+	}
+	f, err := ioutil.TempFile(opts.SpillDir, "b2-upload-")
+	if err != nil {
+		return nil, "", cleanup, err
+	}
+	debugf("upload %s: spilling to %s", name, f.Name())
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	h := sha1.New()
+	w := io.MultiWriter(f, h)
+	if _, err := w.Write(b); err != nil {
+		cleanup()
+		return nil, "", func() {}, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cleanup()
+		return nil, "", func() {}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, "", func() {}, err
+	}
+	return f, hex.EncodeToString(h.Sum(nil)), cleanup, nil
+}
+
+// UploadIfChanged is like UploadWithOptions, but first looks up the latest
+// version of name, and if it has the same length and SHA1 (or
+// large_file_sha1) as the contents of r, it returns its FileInfo without
+// uploading anything. uploaded reports whether a new version was created.
+//
+// The SHA1 of r is computed as UploadWithOptions would, so r is buffered
+// in memory, or spilled to disk according to opts.SpillThreshold, if it's not
+// an io.ReadSeeker or a bytes.Buffer.
+func (b *Bucket) UploadIfChanged(r io.Reader, name string, opts *UploadOptions) (fi *FileInfo, uploaded bool, err error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	info, err := opts.check()
+	if err != nil {
+		return nil, false, err
+	}
+	body, sha1Sum, cleanup, err := uploadReadSeeker(r, name, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cleanup()
+	if buf, ok := r.(*bytes.Buffer); ok {
+		defer buf.Reset() // we are expected to consume it
+	}
+
+	var size int64
+	if sha1Sum != "" {
+		if size, err = body.Seek(0, io.SeekEnd); err != nil {
+			return nil, false, err
+		}
+	} else {
+		// Like UploadWithOptions, the whole body is uploaded from the start.
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+		h := sha1.New()
+		if size, err = io.Copy(h, body); err != nil {
+			return nil, false, err
+		}
+		sha1Sum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	fi, err = b.GetFileInfoByName(name)
+	if err != nil && err != FileNotFoundError {
+		return nil, false, err
+	}
+	if fi != nil && sameContent(fi, sha1Sum, size) {
+		debugf("upload %s: unchanged (%s)", name, fi.ID)
+		return fi, false, nil
+	}
+
+	fi, err = b.upload(body, size, name, sha1Sum, info, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	return fi, true, nil
+}
+
+// sameContent reports whether fi has the given SHA1 and length, as returned
+// by expectedSHA1.
+func sameContent(fi *FileInfo, sha1Sum string, length int64) bool {
+	if fi.Action != "upload" || int64(fi.ContentLength) != length {
+		return false
+	}
+	return expectedSHA1(fi) == sha1Sum
+}
+
+// UploadFile uploads the local file at path as name. It's like
+// UploadWithOptions, but it records the modification time of the file in the
+// "src_last_modified_millis" info, unless opts.Info already sets it.
+//
+// If opts.ContentType is "", the MIME type is detected from the file
+// extension or, failing that, from the contents, falling back to "b2/x-auto".
+//
+// Like with UploadWithOptions, files larger than opts.LargeFileThreshold are
+// uploaded as large files.
+func (b *Bucket) UploadFile(path, name string, opts *UploadOptions) (*FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	o := UploadOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	info := make(map[string]string, len(o.Info)+1)
+	info["src_last_modified_millis"] = strconv.FormatInt(st.ModTime().UnixNano()/1e6, 10)
+	for k, v := range o.Info {
+		info[k] = v
+	}
+	o.Info = info
+	if o.ContentType == "" {
+		if o.ContentType, err = detectContentType(f, path); err != nil {
+			return nil, err
+		}
+	}
+	return b.UploadWithOptions(f, name, &o)
+}
+
+// detectContentType returns the MIME type of f based on the extension of
+// path or on its first 512 bytes, or "b2/x-auto".
+func detectContentType(f *os.File, path string) (string, error) {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t, nil
+	}
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if t := http.DetectContentType(buf[:n]); n > 0 && t != "application/octet-stream" {
+		return t, nil
+	}
+	return "b2/x-auto", nil
+}
+
+// fileInfo returns the checked file info, including the header keys.
+func (opts *UploadOptions) fileInfo() (map[string]string, error) {
+	info := make(map[string]string, len(opts.Info)+5)
+	for k, v := range opts.Info {
+		info[k] = v
+	}
+	for k, v := range map[string]string{
+		"b2-content-disposition": opts.ContentDisposition,
+		"b2-content-language":    opts.ContentLanguage,
+		"b2-expires":             opts.Expires,
+		"b2-cache-control":       opts.CacheControl,
+		"b2-content-encoding":    opts.ContentEncoding,
+	} {
+		if v != "" {
+			info[k] = v
+		}
+	}
+	return checkFileInfo(info)
+}
+
+// check validates opts before an upload, and returns the file info to send.
+func (opts *UploadOptions) check() (map[string]string, error) {
+	info, err := opts.fileInfo()
+	if err != nil {
+		return nil, err
+	}
+	if sse := opts.ServerSideEncryption; sse != nil {
+		if err := sse.check(); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+type sizeReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
 type uploadURL struct {
 	UploadURL, AuthorizationToken string
 }
 
-func (b *Bucket) getUploadURL() (u *uploadURL, err error) {
-	b.uploadURLsMu.Lock()
-	if len(b.uploadURLs) > 0 {
-		u = b.uploadURLs[len(b.uploadURLs)-1]
-		b.uploadURLs = b.uploadURLs[:len(b.uploadURLs)-1]
-	}
-	b.uploadURLsMu.Unlock()
-	if u != nil {
-		return
+func (b *Bucket) getUploadURL() (*pooledURL, error) {
+	if u := b.uploadURLs.get(); u != nil {
+		return u, nil
 	}
+	return b.newUploadURL()
+}
 
+func (b *Bucket) newUploadURL() (*pooledURL, error) {
 	res, err := b.c.doRequest("b2_get_upload_url", map[string]interface{}{
 		"bucketId": b.ID,
 	})
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer drainAndClose(res.Body)
-	err = json.NewDecoder(res.Body).Decode(&u)
-	return
-}
-
-func (b *Bucket) putUploadURL(u *uploadURL) {
-	b.uploadURLsMu.Lock()
-	defer b.uploadURLsMu.Unlock()
-	b.uploadURLs = append(b.uploadURLs, u)
+	var u uploadURL
+	if err := json.NewDecoder(res.Body).Decode(&u); err != nil {
+		return nil, err
+	}
+	return &pooledURL{url: u.UploadURL, token: u.AuthorizationToken, obtained: time.Now()}, nil
 }
 
 // UploadWithSHA1 is like Upload, but allows the caller to specify previously
 // known SHA1 and length of the file. It never does any buffering, nor does it
-// retry on failure.
+// retry on failure. It's equivalent to UploadWithSHA1AndOptions with only
+// ContentType set.
 //
 // Note that retrying on most upload failures, not just error handling, is
 // mandatory by the B2 API documentation. If the error Status is Unauthorized,
 // a call to (*Client).LoginInfo(true) should be performed first.
 //
-// sha1Sum should be the hex encoding of the SHA1 sum of what will be read from r.
+// sha1Sum should be the hex encoding of the SHA1 sum of what will be read from r,
+// or HexDigitsAtEnd to have it computed while uploading and sent after the body.
 //
 // This is an advanced interface, most clients should use Upload, and consider
 // passing it a bytes.Buffer or io.ReadSeeker to avoid buffering.
 func (b *Bucket) UploadWithSHA1(r io.Reader, name, mimeType, sha1Sum string, length int64) (*FileInfo, error) {
+	return b.UploadWithSHA1AndOptions(r, name, sha1Sum, length, &UploadOptions{ContentType: mimeType})
+}
+
+// UploadWithSHA1AndOptions is like UploadWithSHA1, but accepts additional
+// options. It never uses the large file API, so opts.LargeFileThreshold,
+// PartSize, Concurrency and Resume are ignored.
+//
+// If the name, length or SHA1 reported by B2 don't match what was sent, an
+// *IntegrityError is returned.
+func (b *Bucket) UploadWithSHA1AndOptions(r io.Reader, name, sha1Sum string, length int64, opts *UploadOptions) (*FileInfo, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	info, err := opts.check()
+	if err != nil {
+		return nil, err
+	}
+	t := newProgressTracker(opts.Progress, opts.ProgressInterval, length)
+	fi, err := b.uploadWithSHA1(t.reader(r), name, sha1Sum, length, info, opts)
+	if err != nil {
+		return nil, err
+	}
+	t.done()
+	return fi, nil
+}
+
+// uploadWithSHA1 makes a single upload attempt. info must be the result of
+// opts.check.
+func (b *Bucket) uploadWithSHA1(r io.Reader, name, sha1Sum string, length int64, info map[string]string, opts *UploadOptions) (*FileInfo, error) {
 	uurl, err := b.getUploadURL()
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", uurl.UploadURL, ioutil.NopCloser(r))
+	rr := &readErrRecorder{r: limitReader(r, &b.c.uploadLimiter, opts.RateLimiter)}
+	body, sentSHA1 := newUploadBody(rr, sha1Sum, length)
+	req, err := http.NewRequest("POST", uurl.url, body)
 	if err != nil {
 		return nil, err
 	}
-	req.ContentLength = length
-	req.Header.Set("Authorization", uurl.AuthorizationToken)
+	req.ContentLength = uploadContentLength(sha1Sum, length)
+	req.Header.Set("Authorization", uurl.token)
 	req.Header.Set("X-Bz-File-Name", url.QueryEscape(name))
-	req.Header.Set("Content-Type", mimeType)
+	req.Header.Set("Content-Type", opts.ContentType)
 	req.Header.Set("X-Bz-Content-Sha1", sha1Sum)
+	setInfoHeaders(req.Header, info)
+	opts.ServerSideEncryption.setUploadHeaders(req.Header)
 
 	res, err := b.c.hc.Do(req)
 	if err != nil {
 		debugf("upload %s: %s", name, err)
+		b.uploadURLs.release(uurl, err, rr.error())
 		return nil, err
 	}
 	debugf("upload %s (%d %s)", name, length, sha1Sum)
 	defer drainAndClose(res.Body)
 
 	fi := fileInfoObj{}
-	if err = json.NewDecoder(res.Body).Decode(&fi); err != nil {
+	err = json.NewDecoder(res.Body).Decode(&fi)
+	b.uploadURLs.release(uurl, err, nil)
+	if err != nil {
 		return nil, err
 	}
-	b.putUploadURL(uurl)
-	return fi.makeFileInfo(), nil
+	f := fi.makeFileInfo()
+	if err := checkUploadedFile(f, name, sentSHA1(), length); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// HexDigitsAtEnd can be passed as the SHA1 to UploadWithSHA1 and UploadPart
+// to have the SHA1 computed while the body is sent, and appended after it.
+const HexDigitsAtEnd = "hex_digits_at_end"
+
+// newUploadBody returns the request body for an upload of length bytes from r,
+// followed by their hex SHA1 if sha1Sum is HexDigitsAtEnd. The returned
+// function returns the SHA1 the upload response should report, once the
+// body was read completely.
+func newUploadBody(r io.Reader, sha1Sum string, length int64) (io.ReadCloser, func() string) {
+	if sha1Sum != HexDigitsAtEnd {
+		return ioutil.NopCloser(r), func() string { return sha1Sum }
+	}
+	sr := &sha1AtEndReader{r: io.LimitReader(r, length), h: sha1.New()}
+	return ioutil.NopCloser(sr), func() string { return hex.EncodeToString(sr.h.Sum(nil)) }
+}
+
+// uploadContentLength returns the Content-Length of the body returned by
+// newUploadBody.
+func uploadContentLength(sha1Sum string, length int64) int64 {
+	if sha1Sum != HexDigitsAtEnd {
+		return length
+	}
+	return length + sha1.Size*2
+}
+
+// sha1AtEndReader reads from r, and then returns the hex SHA1 of what was read.
+type sha1AtEndReader struct {
+	r       io.Reader
+	h       hash.Hash
+	trailer io.Reader // set once r returned EOF
+}
+
+func (r *sha1AtEndReader) Read(p []byte) (int, error) {
+	if r.trailer != nil {
+		return r.trailer.Read(p)
+	}
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF {
+		r.trailer = strings.NewReader(hex.EncodeToString(r.h.Sum(nil)))
+		if n == 0 {
+			return r.trailer.Read(p)
+		}
+		err = nil
+	}
+	return n, err
 }
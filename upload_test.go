@@ -3,10 +3,21 @@ package b2_test
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/FiloSottile/b2"
 )
 
 func TestUploadError(t *testing.T) {
@@ -103,3 +114,471 @@ func TestUploadReader(t *testing.T) {
 		t.Error("Reader is not empty")
 	}
 }
+
+func TestUploadInfo(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	info := map[string]string{
+		"plain":      "value",
+		"Mixed_Case": "spaces and + signs/ünïcödé %20",
+	}
+	content := make([]byte, 1234)
+	rand.Read(content)
+	fi, err := b.UploadWithOptions(bytes.NewReader(content), "foo-file", &b2.UploadOptions{
+		Info: info,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+
+	fi, err = c.GetFileInfoByID(fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, fi2, err := c.DownloadFileByID(fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	for _, fi := range []*b2.FileInfo{fi, fi2} {
+		if fi.CustomMetadata["plain"] != "value" {
+			t.Errorf("wrong plain info: %q", fi.CustomMetadata["plain"])
+		}
+		if fi.CustomMetadata["mixed_case"] != info["Mixed_Case"] {
+			t.Errorf("wrong mixed_case info: %q", fi.CustomMetadata["mixed_case"])
+		}
+	}
+
+	for _, info := range []map[string]string{
+		{"bad key": "x"},
+		{strings.Repeat("k", 51): "x"},
+		{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6",
+			"g": "7", "h": "8", "i": "9", "j": "10", "k": "11"},
+		{"big": strings.Repeat("x", 7000)},
+	} {
+		if _, err := b.UploadWithOptions(bytes.NewReader(content), "foo-file", &b2.UploadOptions{
+			Info: info,
+		}); err == nil {
+			t.Errorf("expected an error for info %.50v", info)
+		}
+	}
+}
+
+func TestUploadHeaders(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	opts := &b2.UploadOptions{
+		ContentDisposition: `attachment; filename="foo.txt"`,
+		ContentLanguage:    "en-US",
+		Expires:            "Thu, 01 Dec 2044 16:00:00 GMT",
+		CacheControl:       "max-age=3600",
+		ContentEncoding:    "gzip",
+	}
+	content := make([]byte, 1234)
+	rand.Read(content)
+	fi, err := b.UploadWithOptions(bytes.NewReader(content), "foo-file", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+
+	fi1, err := c.GetFileInfoByID(fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, fi2, err := c.DownloadFileByID(fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, content) {
+		t.Error("Content-Encoding caused the body to be altered")
+	}
+	rc, fi3, err := c.DownloadFileByName(b.Name, "foo-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	for _, fi := range []*b2.FileInfo{fi, fi1, fi2, fi3} {
+		if fi.ContentDisposition != opts.ContentDisposition ||
+			fi.ContentLanguage != opts.ContentLanguage ||
+			fi.Expires != opts.Expires ||
+			fi.CacheControl != opts.CacheControl ||
+			fi.ContentEncoding != opts.ContentEncoding {
+			t.Errorf("mismatched headers: %q %q %q %q %q", fi.ContentDisposition,
+				fi.ContentLanguage, fi.Expires, fi.CacheControl, fi.ContentEncoding)
+		}
+	}
+}
+
+func TestUploadTrailingSHA1(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	li, err := c.LoginInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partSize := li.AbsoluteMinimumPartSize
+
+	for _, size := range []int64{123456, 2*partSize + 1234} {
+		content := make([]byte, size)
+		rand.Read(content)
+		fi, err := b.UploadWithOptions(bytes.NewReader(content), "foo-file", &b2.UploadOptions{
+			TrailingSHA1:       true,
+			PartSize:           partSize,
+			LargeFileThreshold: partSize,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.DeleteFile(fi.ID, fi.Name)
+		if int64(fi.ContentLength) != size {
+			t.Error("mismatched fi.ContentLength", fi.ContentLength)
+		}
+		if size < partSize {
+			digest := sha1.Sum(content)
+			if fi.ContentSHA1 != hex.EncodeToString(digest[:]) {
+				t.Error("wrong SHA1", fi.ContentSHA1)
+			}
+		}
+	}
+
+	content := make([]byte, 1234)
+	rand.Read(content)
+	fi, err := b.UploadWithSHA1(bytes.NewReader(content), "foo-file", "", b2.HexDigitsAtEnd, 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	digest := sha1.Sum(content)
+	if fi.ContentSHA1 != hex.EncodeToString(digest[:]) {
+		t.Error("wrong SHA1", fi.ContentSHA1)
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	content := make([]byte, 123456)
+	rand.Read(content)
+	var last b2.Progress
+	var calls int
+	fi, err := b.UploadWithOptions(bytes.NewReader(content), "foo-file", &b2.UploadOptions{
+		Progress: func(p b2.Progress) {
+			if p.Bytes < last.Bytes && !p.Retry {
+				t.Errorf("progress went back without a retry: %+v -> %+v", last, p)
+			}
+			last = p
+			calls++
+		},
+		ProgressInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	if calls < 2 {
+		t.Errorf("progress reported only %d times", calls)
+	}
+	if last.Bytes != 123456 || last.Total != 123456 || last.Attempt < 1 {
+		t.Errorf("wrong final progress: %+v", last)
+	}
+
+	last, calls = b2.Progress{}, 0
+	rc, _, err := c.DownloadFileByIDWithOptions(fi.ID, &b2.DownloadOptions{
+		Progress: func(p b2.Progress) {
+			last = p
+			calls++
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if last.Bytes != 123456 || last.Total != 123456 {
+		t.Errorf("wrong final download progress: %+v", last)
+	}
+}
+
+func TestUploadRateLimit(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	content := make([]byte, 200*1024)
+	rand.Read(content)
+	l := b2.NewRateLimiter(200 * 1024)
+	start := time.Now()
+	fi, err := b.UploadWithOptions(bytes.NewReader(content), "foo-file", &b2.UploadOptions{
+		RateLimiter: l,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	if d := time.Since(start); d < 800*time.Millisecond {
+		t.Errorf("upload of 200KiB at 200KiB/s took only %v", d)
+	}
+
+	c.SetDownloadRateLimiter(l)
+	defer c.SetDownloadRateLimiter(nil)
+	l.SetRate(400 * 1024)
+	start = time.Now()
+	rc, _, err := c.DownloadFileByID(fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("download of 200KiB at 400KiB/s took only %v", d)
+	}
+}
+
+func TestUploadFilePath(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	dir, err := ioutil.TempDir("", "b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "foo.txt")
+	if err := ioutil.WriteFile(path, []byte("hello, world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2017, 2, 7, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := b.UploadFile(path, "foo-file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	if !strings.HasPrefix(fi.ContentType, "text/plain") {
+		t.Error("wrong ContentType", fi.ContentType)
+	}
+	if fi.CustomMetadata["src_last_modified_millis"] != "1486468800000" {
+		t.Error("wrong src_last_modified_millis", fi.CustomMetadata)
+	}
+
+	fi, err = b.UploadFile(path, "foo-file", &b2.UploadOptions{ContentType: "application/x-foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	if fi.ContentType != "application/x-foo" {
+		t.Error("ContentType not forced", fi.ContentType)
+	}
+}
+
+func TestUploadURLPool(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	b.SetUploadURLPoolLimits(2, time.Hour)
+	if err := b.PrewarmUploadURLs(3); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.UploadURLPoolStats(); s.Idle != 2 || s.Dropped != 1 {
+		t.Errorf("unexpected stats after prewarm: %+v", s)
+	}
+
+	content := make([]byte, 1234)
+	rand.Read(content)
+	for i := 0; i < 3; i++ {
+		fi, err := b.Upload(bytes.NewReader(content), "foo-file", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.DeleteFile(fi.ID, fi.Name)
+	}
+	if s := b.UploadURLPoolStats(); s.Hits != 3 || s.Misses != 0 {
+		t.Errorf("unexpected stats after uploads: %+v", s)
+	}
+
+	// A failing reader must not evict the URL.
+	r := io.MultiReader(bytes.NewReader(content[:100]), errorReader{})
+	sum := sha1.Sum(content)
+	if _, err := b.UploadWithSHA1(r, "foo-file", "", hex.EncodeToString(sum[:]), int64(len(content))); err == nil {
+		t.Error("upload with failing reader succeeded")
+	}
+	if s := b.UploadURLPoolStats(); s.Idle != 2 || s.Evicted != 0 {
+		t.Errorf("unexpected stats after reader failure: %+v", s)
+	}
+
+	b.SetUploadURLPoolLimits(2, time.Nanosecond)
+	if s := b.UploadURLPoolStats(); s.Idle != 0 || s.Expired != 2 {
+		t.Errorf("unexpected stats after expiry: %+v", s)
+	}
+}
+
+type errorReader struct{}
+
+func (errorReader) Read(p []byte) (int, error) {
+	return 0, errors.New("test reader failure")
+}
+
+// failingTransport fails the next fails upload requests.
+type failingTransport struct {
+	http.RoundTripper
+	mu    sync.Mutex
+	fails int
+}
+
+func (t *failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	fail := t.fails > 0 && strings.Contains(r.URL.Path, "/b2_upload_file/")
+	if fail {
+		t.fails--
+	}
+	t.mu.Unlock()
+	if fail {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, errors.New("test transport failure")
+	}
+	return t.RoundTripper.RoundTrip(r)
+}
+
+func TestUploadURLPoolEviction(t *testing.T) {
+	ft := &failingTransport{RoundTripper: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}}
+	accountID, applicationKey := getCredentials(t)
+	c, err := b2.NewClient(accountID, applicationKey, &http.Client{Transport: ft})
+	if err != nil {
+		t.Fatal("While authenticating:", err)
+	}
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	if err := b.PrewarmUploadURLs(2); err != nil {
+		t.Fatal(err)
+	}
+	ft.mu.Lock()
+	ft.fails = 1
+	ft.mu.Unlock()
+
+	content := make([]byte, 1234)
+	rand.Read(content)
+	fi, err := b.Upload(bytes.NewReader(content), "foo-file", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	if s := b.UploadURLPoolStats(); s.Evicted == 0 {
+		t.Errorf("unexpected stats after transport failure: %+v", s)
+	}
+}
+
+func TestUploadIfChanged(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	content := make([]byte, 123456)
+	rand.Read(content)
+	fi, uploaded, err := b.UploadIfChanged(bytes.NewReader(content), "foo-file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	if !uploaded {
+		t.Error("first upload was skipped")
+	}
+
+	fi2, uploaded, err := b.UploadIfChanged(bytes.NewBuffer(content), "foo-file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded || fi2.ID != fi.ID {
+		t.Error("unchanged content was uploaded again", fi2.ID)
+	}
+
+	// A ReadSeeker is compared from the start, like it's uploaded.
+	r := bytes.NewReader(content)
+	r.Seek(1000, io.SeekStart)
+	fi2, uploaded, err = b.UploadIfChanged(r, "foo-file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded || fi2.ID != fi.ID {
+		t.Error("unchanged content at an offset was uploaded again", fi2.ID)
+	}
+
+	content[0]++
+	fi3, uploaded, err := b.UploadIfChanged(bytes.NewReader(content), "foo-file", &b2.UploadOptions{
+		TrailingSHA1: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi3.ID, fi3.Name)
+	if !uploaded || fi3.ID == fi.ID {
+		t.Error("changed content was not uploaded", fi3.ID)
+	}
+	// The SHA1 computed for the comparison is sent, instead of a trailing one.
+	digest := sha1.Sum(content)
+	if fi3.ContentSHA1 != hex.EncodeToString(digest[:]) {
+		t.Error("mismatched upload SHA1", fi3.ContentSHA1)
+	}
+}
+
+func TestUploadSpill(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	dir, err := ioutil.TempDir("", "b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 123456)
+	rand.Read(content)
+	r := ioutil.NopCloser(bytes.NewReader(content)) // shadow Seek method
+	fi, err := b.UploadWithOptions(r, "foo-file", &b2.UploadOptions{
+		SpillThreshold: 1000,
+		SpillDir:       dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(fi.ID, fi.Name)
+	digest := sha1.Sum(content)
+	if fi.ContentLength != len(content) || fi.ContentSHA1 != hex.EncodeToString(digest[:]) {
+		t.Error("mismatched upload", fi.ContentLength, fi.ContentSHA1)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Error("temporary file was not removed", files, err)
+	}
+}
@@ -0,0 +1,210 @@
+package b2
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// A Writer uploads the data written to it as a file, without knowing its
+// length in advance. It's returned by (*Bucket).NewWriter.
+//
+// Data is buffered one part at a time. If the file fits in a single part, it
+// is sent with a simple upload when the Writer is closed; otherwise it's
+// uploaded as a large file, with the parts sent in the background as they
+// fill up. At most opts.Concurrency parts are held in memory at any time, so
+// memory use is bounded by Concurrency × PartSize.
+//
+// Since the number of parts of a large file is limited to 10000, the file
+// can be at most 10000 × PartSize bytes long. For the same reason, the Total
+// reported to opts.Progress is -1 while uploading a large file.
+//
+// A Writer is not safe for concurrent use.
+type Writer struct {
+	b        *Bucket
+	name     string
+	opts     *UploadOptions
+	partSize int64
+
+	buf   []byte
+	sem   chan struct{} // one token per buffer in use
+	free  chan []byte
+	lf    *LargeFile
+	sha1s []string
+	size  int64 // of the parts flushed so far
+	t     *progressTracker
+
+	wg  sync.WaitGroup
+	mu  sync.Mutex // protects err
+	err error
+
+	closed bool
+	fi     *FileInfo
+}
+
+// NewWriter returns a Writer that uploads a file named name. The caller must
+// call Close to complete the upload, and check its error.
+//
+// opts.LargeFileThreshold is ignored, since the length is not known.
+func (b *Bucket) NewWriter(name string, opts *UploadOptions) *Writer {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	return &Writer{
+		b:        b,
+		name:     name,
+		opts:     opts,
+		partSize: b.c.partSize(opts, 0),
+		t:        newProgressTracker(opts.Progress, opts.ProgressInterval, -1),
+		sem:      make(chan struct{}, concurrency),
+		free:     make(chan []byte, concurrency),
+	}
+}
+
+func (w *Writer) getErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Writer) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// Write buffers p, and blocks if all the part buffers are full and waiting
+// to be uploaded. If a part upload failed, Write returns its error.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, errors.New("b2: write to closed Writer")
+	}
+	for len(p) > 0 {
+		if err := w.getErr(); err != nil {
+			return n, err
+		}
+		if w.buf != nil && len(w.buf) == cap(w.buf) {
+			// Flush only when more data arrives, so that a file that
+			// fits in one part is sent with a simple upload.
+			if err := w.flushPart(); err != nil {
+				w.setErr(err)
+				return n, err
+			}
+		}
+		if w.buf == nil {
+			w.buf = w.newBuffer()
+		}
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		n += c
+		p = p[c:]
+	}
+	return n, nil
+}
+
+func (w *Writer) newBuffer() []byte {
+	w.sem <- struct{}{}
+	select {
+	case buf := <-w.free:
+		return buf[:0]
+	default:
+		return make([]byte, 0, w.partSize)
+	}
+}
+
+func (w *Writer) releaseBuffer(buf []byte) {
+	w.free <- buf
+	<-w.sem
+}
+
+// flushPart starts the upload of the current buffer as the next part.
+func (w *Writer) flushPart() error {
+	if w.lf == nil {
+		lf, err := w.b.StartLargeFileWithOptions(w.name, w.opts)
+		if err != nil {
+			return err
+		}
+		w.lf = lf
+	}
+
+	buf := w.buf
+	w.buf = nil
+	digest := sha1.Sum(buf)
+	w.sha1s = append(w.sha1s, hex.EncodeToString(digest[:]))
+	w.size += int64(len(buf))
+	p := &largeFilePart{
+		number:  len(w.sha1s),
+		length:  int64(len(buf)),
+		sha1Sum: w.sha1s[len(w.sha1s)-1],
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer w.releaseBuffer(buf)
+		if err := w.lf.uploadPartWithRetries(bytes.NewReader(buf), p, w.t, w.opts); err != nil {
+			w.setErr(err)
+		}
+	}()
+	return nil
+}
+
+// Close uploads any buffered data, waits for all parts to be uploaded, and
+// completes the file. After Close returns nil, FileInfo returns the result.
+//
+// If the upload fails, the unfinished large file is canceled.
+func (w *Writer) Close() error {
+	if w.closed {
+		return errors.New("b2: Writer already closed")
+	}
+	w.closed = true
+
+	if w.lf == nil {
+		if err := w.getErr(); err != nil {
+			return err
+		}
+		fi, err := w.b.UploadWithOptions(bytes.NewReader(w.buf), w.name, w.opts)
+		if err != nil {
+			return err
+		}
+		w.fi = fi
+		return nil
+	}
+
+	if len(w.buf) > 0 && w.getErr() == nil {
+		if err := w.flushPart(); err != nil {
+			w.setErr(err)
+		}
+	}
+	w.wg.Wait()
+	if err := w.getErr(); err != nil {
+		if err := w.b.c.CancelLargeFile(w.lf.ID); err != nil {
+			debugf("upload %s: cancel: %s", w.name, err)
+		}
+		return err
+	}
+	fi, err := w.b.c.FinishLargeFile(w.lf.ID, w.sha1s)
+	if err != nil {
+		return err
+	}
+	if err := checkUploadedFile(fi, w.name, "", w.size); err != nil {
+		return err
+	}
+	w.t.done()
+	w.fi = fi
+	return nil
+}
+
+// FileInfo returns the FileInfo of the uploaded file. It must only be called
+// after Close returned nil.
+func (w *Writer) FileInfo() *FileInfo {
+	return w.fi
+}
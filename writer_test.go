@@ -0,0 +1,88 @@
+package b2_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FiloSottile/b2"
+)
+
+func TestWriter(t *testing.T) {
+	c := getClient(t)
+	b := getBucket(t, c)
+	defer deleteBucket(t, b)
+
+	li, err := c.LoginInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partSize := li.AbsoluteMinimumPartSize
+
+	for _, size := range []int64{0, 123456, partSize, 2*partSize + 1234} {
+		file := make([]byte, size)
+		rand.Read(file)
+
+		w := b.NewWriter("test-writer", &b2.UploadOptions{
+			PartSize:    partSize,
+			Concurrency: 2,
+		})
+		for p := file; len(p) > 0; {
+			n := 1 << 20
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		fi := w.FileInfo()
+		defer c.DeleteFile(fi.ID, fi.Name)
+		if int64(fi.ContentLength) != size {
+			t.Errorf("mismatched file length: %d, expected %d", fi.ContentLength, size)
+		}
+
+		rc, _, err := c.DownloadFileByID(fi.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, file) {
+			t.Errorf("mismatch in file contents (size %d)", size)
+		}
+	}
+}
+
+func TestWriterDefaultPartSize(t *testing.T) {
+	// The fake login has no part sizes.
+	c, err := b2.NewClient("test", "test", &http.Client{Transport: &cuttingTransport{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := c.BucketByID("test-bucket").NewWriter("foo-file", nil)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 1234))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Write did not return")
+	}
+}